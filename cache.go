@@ -0,0 +1,449 @@
+package uf
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Size-bounded LRU cache of GET and HEAD responses. Wrap the handlers of
+// routes that are safe to cache with Handler:
+//
+//	cache := uf.NewResponseCache(32<<20, time.Minute)
+//
+//	server.Get("/book/:id", cache.Handler(book.HandleGet))
+//	server.Put("/book/:id", book.HandlePut) // calls cache.InvalidateTag("books")
+//
+// Responses honour Cache-Control (no-store, no-cache, private, max-age and
+// s-maxage) and Vary. Concurrent misses for the same key are coalesced so
+// only a single handler call runs. Per RFC 9111 section 3.5, responses to
+// requests with an Authorization header are only stored, and only served to
+// such requests, if they are explicitly shareable (public, s-maxage or
+// must-revalidate).
+type ResponseCache struct {
+	mutex    sync.Mutex
+	maxBytes int
+	size     int
+	ttl      time.Duration
+	lru      *list.List
+	entries  map[string]*list.Element
+	variants map[string]map[string]struct{}
+	vary     map[string][]string
+	tags     map[string]map[string]struct{}
+	calls    map[string]*cacheCall
+}
+
+type cacheEntry struct {
+	key     string
+	uri     string
+	code    int
+	header  http.Header
+	body    []byte
+	tags    []string
+	stored  time.Time
+	expires time.Time
+
+	// may be served to requests with an Authorization header
+	shared bool
+}
+
+// A handler call shared by concurrent misses.
+type cacheCall struct {
+	done  chan struct{}
+	entry *cacheEntry
+}
+
+type cacheTagsKey struct{}
+
+// Create a ResponseCache holding at most maxBytes of response bodies and
+// headers. Responses without a max-age are cached for ttl.
+func NewResponseCache(maxBytes int, ttl time.Duration) *ResponseCache {
+	return &ResponseCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+		variants: make(map[string]map[string]struct{}),
+		vary:     make(map[string][]string),
+		tags:     make(map[string]map[string]struct{}),
+		calls:    make(map[string]*cacheCall),
+	}
+}
+
+// Tag the response being cached for r so it can be invalidated with
+// ResponseCache.InvalidateTag. Has no effect outside a ResponseCache handler.
+func CacheTag(r *http.Request, tags ...string) {
+	if p, ok := r.Context().Value(cacheTagsKey{}).(*[]string); ok {
+		*p = append(*p, tags...)
+	}
+}
+
+// Remove every cached variant of the request URI key. Eg. "/book/1?format=short".
+func (c *ResponseCache) Invalidate(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for k := range c.variants[key] {
+		c.remove(c.entries[k])
+	}
+}
+
+// Remove every cached response tagged with tag.
+func (c *ResponseCache) InvalidateTag(tag string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for k := range c.tags[tag] {
+		c.remove(c.entries[k])
+	}
+}
+
+// Number of bytes currently cached.
+func (c *ResponseCache) Size() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.size
+}
+
+// Wrap h, serving GET and HEAD requests from the cache where possible.
+func (c *ResponseCache) Handler(h Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return h(w, r)
+		}
+
+		directives := parseCacheControl(r.Header.Get("Cache-Control"))
+
+		if _, ok := directives["no-store"]; ok {
+			return h(w, r)
+		}
+
+		uri := r.URL.RequestURI()
+		_, revalidate := directives["no-cache"]
+		authorized := r.Header.Get("Authorization") != ""
+
+		c.mutex.Lock()
+		key := c.key(uri, r)
+
+		if !revalidate {
+			if entry := c.lookup(key); entry != nil && (entry.shared || !authorized) {
+				c.mutex.Unlock()
+
+				return entry.write(w)
+			}
+
+			if call, ok := c.calls[key]; ok {
+				// another request is already filling this key
+				c.mutex.Unlock()
+				<-call.done
+
+				if call.entry != nil && (call.entry.shared || !authorized) {
+					return call.entry.write(w)
+				}
+
+				return h(w, r)
+			}
+		}
+
+		call := &cacheCall{done: make(chan struct{})}
+		c.calls[key] = call
+		c.mutex.Unlock()
+
+		defer func() {
+			c.mutex.Lock()
+
+			if c.calls[key] == call {
+				delete(c.calls, key)
+			}
+
+			c.mutex.Unlock()
+			close(call.done)
+		}()
+
+		// record the response while passing it through
+		var tags []string
+		rec := &cacheRecorder{ResponseWriter: w}
+		e := h(rec, r.WithContext(context.WithValue(r.Context(), cacheTagsKey{}, &tags)))
+
+		if e == nil {
+			call.entry = c.store(uri, r, rec, tags)
+		}
+
+		return e
+	}
+}
+
+// Build the storage key from the request method and URI and the values of
+// the headers previous responses for the URI varied by. The method keeps the
+// empty bodies of HEAD responses from being served to GET requests. Requires
+// the mutex.
+func (c *ResponseCache) key(uri string, r *http.Request) string {
+	b := strings.Builder{}
+	b.WriteString(r.Method)
+	b.WriteByte(' ')
+	b.WriteString(uri)
+
+	for _, name := range c.vary[uri] {
+		b.WriteByte(0)
+		b.WriteString(r.Header.Get(name))
+	}
+
+	return b.String()
+}
+
+// Get an unexpired entry and mark it as recently used. Requires the mutex.
+func (c *ResponseCache) lookup(key string) *cacheEntry {
+	el, ok := c.entries[key]
+
+	if !ok {
+		return nil
+	}
+
+	entry := el.Value.(*cacheEntry)
+
+	if time.Now().After(entry.expires) {
+		c.remove(el)
+
+		return nil
+	}
+
+	c.lru.MoveToFront(el)
+
+	return entry
+}
+
+// Store the recorded response if it is cacheable. Returns the stored entry or nil.
+func (c *ResponseCache) store(uri string, r *http.Request, rec *cacheRecorder, tags []string) *cacheEntry {
+	if rec.code != http.StatusOK || rec.flushed {
+		return nil
+	}
+
+	h := rec.header
+	directives := parseCacheControl(h.Get("Cache-Control"))
+
+	for _, d := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[d]; ok {
+			return nil
+		}
+	}
+
+	if h.Get("Set-Cookie") != "" {
+		return nil
+	}
+
+	shared := false
+
+	for _, d := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[d]; ok {
+			shared = true
+		}
+	}
+
+	if r.Header.Get("Authorization") != "" && !shared {
+		// the response may be specific to the user
+		return nil
+	}
+
+	ttl := c.ttl
+
+	for _, d := range []string{"max-age", "s-maxage"} {
+		if s, e := strconv.Atoi(directives[d]); e == nil {
+			ttl = time.Duration(s) * time.Second
+		}
+	}
+
+	var vary []string
+
+	for _, v := range h.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "*" {
+				return nil
+			} else if name != "" {
+				vary = append(vary, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	now := time.Now()
+	entry := &cacheEntry{
+		uri:     uri,
+		code:    rec.code,
+		header:  h,
+		body:    rec.body.Bytes(),
+		tags:    tags,
+		stored:  now,
+		expires: now.Add(ttl),
+		shared:  shared,
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.vary[uri] = vary
+	entry.key = c.key(uri, r)
+
+	if entry.cost() > c.maxBytes {
+		return nil
+	}
+
+	if el, ok := c.entries[entry.key]; ok {
+		c.remove(el)
+	}
+
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	c.size += entry.cost()
+	addToSet(c.variants, uri, entry.key)
+
+	for _, tag := range tags {
+		addToSet(c.tags, tag, entry.key)
+	}
+
+	// evict the least recently used entries
+	for c.size > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+
+	return entry
+}
+
+// Remove an entry from every index. Requires the mutex.
+func (c *ResponseCache) remove(el *list.Element) {
+	if el == nil {
+		return
+	}
+
+	entry := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.cost()
+	removeFromSet(c.variants, entry.uri, entry.key)
+
+	for _, tag := range entry.tags {
+		removeFromSet(c.tags, tag, entry.key)
+	}
+}
+
+func addToSet(m map[string]map[string]struct{}, k, v string) {
+	if m[k] == nil {
+		m[k] = make(map[string]struct{})
+	}
+
+	m[k][v] = struct{}{}
+}
+
+func removeFromSet(m map[string]map[string]struct{}, k, v string) {
+	delete(m[k], v)
+
+	if len(m[k]) == 0 {
+		delete(m, k)
+	}
+}
+
+// Approximate size of the entry in bytes.
+func (e *cacheEntry) cost() int {
+	n := len(e.body) + len(e.key)
+
+	for k, v := range e.header {
+		n += len(k)
+
+		for _, s := range v {
+			n += len(s)
+		}
+	}
+
+	return n
+}
+
+// Send the cached response. Headers already set by middleware are kept.
+func (e *cacheEntry) write(w http.ResponseWriter) error {
+	h := w.Header()
+
+	for k, v := range e.header {
+		if _, ok := h[k]; !ok {
+			h[k] = v
+		}
+	}
+
+	h.Set("Age", strconv.Itoa(int(time.Since(e.stored).Seconds())))
+	w.WriteHeader(e.code)
+	_, err := w.Write(e.body)
+
+	return err
+}
+
+// Split a Cache-Control header into its directives and their (unquoted) values.
+func parseCacheControl(header string) map[string]string {
+	directives := make(map[string]string)
+
+	for _, part := range strings.Split(header, ",") {
+		part = strings.TrimSpace(part)
+
+		if part == "" {
+			continue
+		}
+
+		name, value := part, ""
+
+		if i := strings.IndexByte(part, '='); i >= 0 {
+			name, value = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+
+		directives[strings.ToLower(name)] = value
+	}
+
+	return directives
+}
+
+// Records a response while passing it through to the client.
+type cacheRecorder struct {
+	http.ResponseWriter
+	header  http.Header
+	body    bytes.Buffer
+	code    int
+	flushed bool
+}
+
+// Snapshot the headers as set by the handler, before writers further
+// down the chain (eg. compression) modify them.
+func (cr *cacheRecorder) commit(code int) {
+	if cr.code == 0 {
+		cr.code = code
+		cr.header = cr.Header().Clone()
+	}
+}
+
+func (cr *cacheRecorder) WriteHeader(code int) {
+	cr.commit(code)
+	cr.ResponseWriter.WriteHeader(code)
+}
+
+func (cr *cacheRecorder) Write(b []byte) (int, error) {
+	cr.commit(http.StatusOK)
+
+	cr.body.Write(b)
+
+	return cr.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher. Streamed responses are not cached.
+func (cr *cacheRecorder) Flush() {
+	cr.flushed = true
+
+	if f, ok := cr.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Allows http.ResponseController to reach the underlying writer.
+func (cr *cacheRecorder) Unwrap() http.ResponseWriter {
+	return cr.ResponseWriter
+}
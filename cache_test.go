@@ -0,0 +1,254 @@
+package uf
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Serve path through q with the request headers in kv.
+func cacheGet(q http.Handler, path string, kv ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+
+	for i := 0; i < len(kv); i += 2 {
+		r.Header.Set(kv[i], kv[i+1])
+	}
+
+	q.ServeHTTP(recorder, r)
+
+	return recorder
+}
+
+func TestResponseCache(t *testing.T) {
+	cache := NewResponseCache(1<<20, time.Minute)
+	var calls int32
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		CacheTag(r, "cars")
+
+		if r.URL.Path == "/private" {
+			w.Header().Set("Cache-Control", "private")
+		}
+
+		return SendJSON(w, GT1{"BMW", "V12 LM", 1998})
+	}), nil, &Config{})
+
+	cacheGet(q, "/car")
+	res := cacheGet(q, "/car")
+
+	if calls != 1 {
+		t.Fatalf("expected a cache hit, handler calls: %d", calls)
+	}
+
+	if res.Header().Get("Age") == "" || !strings.Contains(res.Body.String(), "V12 LM") {
+		t.Errorf("unexpected cached response: %v %s", res.Header(), res.Body)
+	}
+
+	// requests can bypass the cache
+	cacheGet(q, "/car", "Cache-Control", "no-cache")
+
+	if calls != 2 {
+		t.Errorf("no-cache was not honoured, handler calls: %d", calls)
+	}
+
+	// private responses are not stored
+	cacheGet(q, "/private")
+	cacheGet(q, "/private")
+
+	if calls != 4 {
+		t.Errorf("private response was cached, handler calls: %d", calls)
+	}
+
+	cache.InvalidateTag("cars")
+	cacheGet(q, "/car")
+
+	if calls != 5 {
+		t.Errorf("tag was not invalidated, handler calls: %d", calls)
+	}
+
+	cache.Invalidate("/car")
+	cacheGet(q, "/car")
+
+	if calls != 6 {
+		t.Errorf("key was not invalidated, handler calls: %d", calls)
+	}
+}
+
+func TestResponseCacheAuthorization(t *testing.T) {
+	cache := NewResponseCache(1<<20, time.Minute)
+	var calls int32
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+
+		if r.URL.Path == "/public" {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}
+
+		return SendJSON(w, "user: "+r.Header.Get("Authorization"))
+	}), nil, &Config{})
+
+	cacheGet(q, "/me", "Authorization", "alice")
+
+	if res := cacheGet(q, "/me", "Authorization", "bob"); !strings.Contains(res.Body.String(), "bob") {
+		t.Errorf("served another user's response: %s", res.Body)
+	}
+
+	// stored for anonymous requests but not served to authenticated ones
+	cacheGet(q, "/me")
+
+	if res := cacheGet(q, "/me", "Authorization", "bob"); !strings.Contains(res.Body.String(), "bob") {
+		t.Errorf("served an anonymous response to an authenticated request: %s", res.Body)
+	}
+
+	if calls != 4 {
+		t.Errorf("expected every request to reach the handler; calls: %d", calls)
+	}
+
+	// explicitly shareable
+	cacheGet(q, "/public", "Authorization", "alice")
+	cacheGet(q, "/public", "Authorization", "bob")
+
+	if calls != 5 {
+		t.Errorf("expected a public response to be cached; calls: %d", calls)
+	}
+}
+
+func TestResponseCacheVary(t *testing.T) {
+	cache := NewResponseCache(1<<20, time.Minute)
+	var calls int32
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Vary", "Accept-Language")
+
+		return SendJSON(w, r.Header.Get("Accept-Language"))
+	}), nil, &Config{})
+
+	cacheGet(q, "/", "Accept-Language", "en")
+	cacheGet(q, "/", "Accept-Language", "de")
+	res := cacheGet(q, "/", "Accept-Language", "en")
+
+	if calls != 2 {
+		t.Errorf("expected one call per language, handler calls: %d", calls)
+	}
+
+	if res.Body.String() != "\"en\"\n" {
+		t.Errorf("served the wrong variant: %s", res.Body)
+	}
+}
+
+func TestResponseCacheEviction(t *testing.T) {
+	cache := NewResponseCache(300, time.Minute)
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		w.Write(make([]byte, 100))
+
+		return nil
+	}), nil, &Config{})
+
+	cacheGet(q, "/1")
+	cacheGet(q, "/2")
+	cacheGet(q, "/1")
+	cacheGet(q, "/3")
+
+	if _, ok := cache.entries["/2"]; ok {
+		t.Error("least recently used entry was not evicted")
+	}
+
+	if size := cache.Size(); size > 300 {
+		t.Errorf("cache exceeded its bound: %d", size)
+	}
+}
+
+func TestResponseCacheCoalescing(t *testing.T) {
+	cache := NewResponseCache(1<<20, time.Minute)
+	release := make(chan struct{})
+	var calls int32
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		atomic.AddInt32(&calls, 1)
+		<-release
+
+		return SendJSON(w, "slow")
+	}), nil, &Config{})
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if res := cacheGet(q, "/slow"); res.Body.String() != "\"slow\"\n" {
+				t.Errorf("unexpected body: %s", res.Body)
+			}
+		}()
+	}
+
+	// wait for the first miss to start filling the key
+	for atomic.LoadInt32(&calls) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("concurrent misses were not coalesced, handler calls: %d", calls)
+	}
+}
+
+func TestResponseCacheCompression(t *testing.T) {
+	cache := NewResponseCache(1<<20, time.Minute)
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, strings.Repeat("Le Mans ", 200))
+	}), nil, &Config{Compression: &CompressionOptions{}})
+
+	for i := 0; i < 2; i++ {
+		res := cacheGet(q, "/", "Accept-Encoding", "gzip")
+		zr, e := gzip.NewReader(res.Body)
+
+		if e != nil {
+			t.Fatalf("response %d: %v", i, e)
+		}
+
+		if b, e := io.ReadAll(zr); e != nil || !strings.HasPrefix(string(b), `"Le Mans`) {
+			t.Errorf("response %d: unexpected body: %v %.20s", i, e, b)
+		}
+	}
+}
+
+func TestResponseCacheHead(t *testing.T) {
+	cache := NewResponseCache(1<<20, time.Minute)
+	q := newQueue(cache.Handler(func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method == http.MethodHead {
+			// handled without a body
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+
+			return nil
+		}
+
+		return SendJSON(w, GT1{"Porsche", "911 GT1", 1996})
+	}), nil, &Config{})
+
+	recorder := httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, "/car", nil))
+
+	if res := cacheGet(q, "/car"); !strings.Contains(res.Body.String(), "911 GT1") {
+		t.Errorf("HEAD response served to GET: %d %q", res.Code, res.Body)
+	}
+
+	// both are invalidated by URI
+	cache.Invalidate("/car")
+
+	if n := cache.Size(); n != 0 {
+		t.Errorf("expected an empty cache; size: %d", n)
+	}
+}
@@ -0,0 +1,343 @@
+package uf
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response compression configuration.
+type CompressionOptions struct {
+	// Minimum size of a response body in bytes before it is compressed.
+	// Defaults to 1024.
+	MinSize int
+
+	// Media types eligible for compression. A trailing "/*" matches
+	// any subtype. Defaults to application/json and text/*.
+	ContentTypes []string
+
+	// Compression level as accepted by compress/gzip, ie. -2 (Huffman only)
+	// to 9. Zero uses the default level. NewServer panics if it is out of range.
+	Level int
+
+	once  sync.Once
+	gzip  sync.Pool
+	zlib  sync.Pool
+	types []string
+}
+
+// Maximum size of a compressed request body once decompressed by ReadBody.
+// Larger bodies are rejected with a 413 Request Entity Too Large error.
+var MaxDecompressedSize int64 = 10 << 20
+
+// Set defaults and create the writer pools. Panics if the level is invalid.
+func (co *CompressionOptions) init() {
+	if co.Level < gzip.HuffmanOnly || co.Level > gzip.BestCompression {
+		panic(fmt.Sprintf("uf: invalid compression level %d", co.Level))
+	}
+
+	co.once.Do(func() {
+		if co.MinSize <= 0 {
+			co.MinSize = 1024
+		}
+
+		co.types = co.ContentTypes
+
+		if len(co.types) == 0 {
+			co.types = []string{"application/json", "text/*"}
+		}
+
+		level := co.Level
+
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+
+		co.gzip.New = func() interface{} {
+			w, _ := gzip.NewWriterLevel(nil, level)
+
+			return w
+		}
+
+		co.zlib.New = func() interface{} {
+			w, _ := zlib.NewWriterLevel(nil, level)
+
+			return w
+		}
+	})
+}
+
+// Determine whether the media type of contentType is eligible for compression.
+func (co *CompressionOptions) compressible(contentType string) bool {
+	mt, _, e := mime.ParseMediaType(contentType)
+
+	if e != nil {
+		return false
+	}
+
+	for _, t := range co.types {
+		if t == mt || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, t[:len(t)-1])) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Writers returned to the pools must be resettable.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(io.Writer)
+	Flush() error
+}
+
+// Choose the preferred encoding from an Accept-Encoding header.
+// Returns an empty string if neither gzip nor deflate are acceptable.
+// A wildcard (*) only applies to the codings not listed explicitly, so
+// "gzip;q=0, *" selects deflate (RFC 9110 section 12.5.3).
+func negotiateEncoding(header string) string {
+	listed := make(map[string]float64)
+	wildcard := 0.0
+
+	for _, part := range strings.Split(header, ",") {
+		coding, q := parseQuality(part)
+
+		if coding == "*" {
+			wildcard = q
+		} else {
+			listed[coding] = q
+		}
+	}
+
+	best := ""
+	bestQ := 0.0
+
+	// prefer gzip over deflate when equally weighted
+	for _, coding := range []string{"gzip", "deflate"} {
+		q, ok := listed[coding]
+
+		if !ok {
+			q = wildcard
+		}
+
+		if q > bestQ {
+			best = coding
+			bestQ = q
+		}
+	}
+
+	return best
+}
+
+// Split a header list element into its value and quality (q) parameter.
+func parseQuality(part string) (string, float64) {
+	params := strings.Split(part, ";")
+	value := strings.ToLower(strings.TrimSpace(params[0]))
+	q := 1.0
+
+	for _, p := range params[1:] {
+		p = strings.TrimSpace(p)
+
+		if strings.HasPrefix(p, "q=") {
+			if f, e := strconv.ParseFloat(p[2:], 64); e == nil {
+				q = f
+			}
+		}
+	}
+
+	return value, q
+}
+
+// Compresses the response once it is known to be large enough and of
+// an eligible content type. Until then the body is buffered.
+type compressWriter struct {
+	http.ResponseWriter
+	opts     *CompressionOptions
+	encoding string
+	buf      []byte
+	code     int
+	decided  bool
+	cz       resetWriter
+}
+
+// Wrap w if the request accepts a supported encoding. Returns nil otherwise.
+func newCompressWriter(w http.ResponseWriter, r *http.Request, opts *CompressionOptions) *compressWriter {
+	opts.init()
+	w.Header().Add("Vary", "Accept-Encoding")
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+	if encoding == "" {
+		return nil
+	}
+
+	return &compressWriter{ResponseWriter: w, opts: opts, encoding: encoding}
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.code == 0 {
+		cw.code = code
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+
+		if len(cw.buf) < cw.opts.MinSize {
+			return len(b), nil
+		}
+
+		return len(b), cw.decide(true)
+	}
+
+	if cw.cz != nil {
+		return cw.cz.Write(b)
+	}
+
+	return cw.ResponseWriter.Write(b)
+}
+
+// Whether a response with the status code and body (or its start) is
+// compressed. large is whether the body is at least MinSize bytes.
+func (cw *compressWriter) eligible(code int, body []byte, large bool) bool {
+	h := cw.Header()
+	ct := h.Get("Content-Type")
+
+	if ct == "" && len(body) > 0 {
+		ct = http.DetectContentType(body)
+	}
+
+	// partial content describes ranges of the identity encoding
+	return large && h.Get("Content-Encoding") == "" && code >= http.StatusOK &&
+		code != http.StatusNoContent && code != http.StatusPartialContent &&
+		code != http.StatusNotModified && cw.opts.compressible(ct)
+}
+
+// Send the headers and buffered body, compressing if the response is eligible.
+func (cw *compressWriter) decide(large bool) error {
+	cw.decided = true
+	h := cw.Header()
+
+	if cw.code == 0 {
+		cw.code = http.StatusOK
+	}
+
+	if cw.eligible(cw.code, cw.buf, large) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+
+		if cw.encoding == "gzip" {
+			cw.cz = cw.opts.gzip.Get().(resetWriter)
+		} else {
+			cw.cz = cw.opts.zlib.Get().(resetWriter)
+		}
+
+		cw.cz.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.code)
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	var e error
+
+	if cw.cz != nil {
+		_, e = cw.cz.Write(buf)
+	} else {
+		_, e = cw.ResponseWriter.Write(buf)
+	}
+
+	return e
+}
+
+// Flush implements http.Flusher. A streaming response is compressed
+// regardless of its size so far.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide(true)
+	}
+
+	if cw.cz != nil {
+		cw.cz.Flush()
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Finish the response and return the compressor to its pool.
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if cw.code == 0 {
+			// nothing was written; let the server send its default response
+			return nil
+		}
+
+		if e := cw.decide(len(cw.buf) >= cw.opts.MinSize); e != nil {
+			return e
+		}
+	}
+
+	if cw.cz == nil {
+		return nil
+	}
+
+	e := cw.cz.Close()
+
+	if cw.encoding == "gzip" {
+		cw.opts.gzip.Put(cw.cz)
+	} else {
+		cw.opts.zlib.Put(cw.cz)
+	}
+
+	cw.cz = nil
+
+	return e
+}
+
+// Allows http.ResponseController to reach the underlying writer.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Wrap body in a decompressing reader according to the Content-Encoding header.
+func decodeBody(body io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		zr, e := gzip.NewReader(body)
+
+		if e != nil {
+			return nil, BadRequest("Malformed gzip body: " + e.Error())
+		}
+
+		return zr, nil
+	case "deflate":
+		zr, e := zlib.NewReader(body)
+
+		if e != nil {
+			return nil, BadRequest("Malformed deflate body: " + e.Error())
+		}
+
+		return zr, nil
+	}
+
+	return nil, UnsupportedMediaType("Unsupported Content-Encoding: " + encoding)
+}
@@ -0,0 +1,213 @@
+package uf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	cases := map[string]string{
+		"":                         "",
+		"br":                       "",
+		"gzip":                     "gzip",
+		"deflate, gzip":            "gzip",
+		"gzip;q=0.5, deflate":      "deflate",
+		"gzip;q=0, deflate;q=0":    "",
+		"*":                        "gzip",
+		"gzip;q=0, *":              "deflate",
+		"*, gzip;q=0, deflate;q=0": "",
+		"deflate;q=0.5, *;q=0.8":   "gzip",
+		"identity, deflate;q=.1":   "deflate",
+	}
+
+	for header, expected := range cases {
+		if actual := negotiateEncoding(header); actual != expected {
+			t.Errorf("%q: expected: %q, actual: %q", header, expected, actual)
+		}
+	}
+}
+
+func TestCompression(t *testing.T) {
+	cars := make([]GT1, 100)
+
+	for i := range cars {
+		cars[i] = GT1{"Porsche", "911 GT1", 1996}
+	}
+
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		if r.URL.Path == "/small" {
+			return SendJSON(w, cars[0])
+		}
+
+		return SendJSON(w, cars)
+	}, nil, &Config{Compression: &CompressionOptions{}})
+
+	send := func(path, encoding string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		q.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	// large responses are compressed
+	res := send("/", "gzip")
+
+	if v := res.Header().Get("Content-Encoding"); v != "gzip" {
+		t.Fatalf("Content-Encoding: expected: gzip, actual: %q", v)
+	}
+
+	if v := res.Header().Get("Vary"); v != "Accept-Encoding" {
+		t.Errorf("Vary: expected: Accept-Encoding, actual: %q", v)
+	}
+
+	zr, e := gzip.NewReader(res.Body)
+
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if b, e := io.ReadAll(zr); e != nil || !bytes.HasPrefix(b, []byte(`[{"Manufacturer":"Porsche"`)) {
+		t.Errorf("could not decompress the body: %v %.30s", e, b)
+	}
+
+	// deflate uses the zlib format
+	res = send("/", "deflate")
+
+	if _, e = zlib.NewReader(res.Body); e != nil {
+		t.Errorf("deflate: %v", e)
+	}
+
+	// small responses are sent as is
+	res = send("/small", "gzip")
+
+	if v := res.Header().Get("Content-Encoding"); v != "" {
+		t.Errorf("Content-Encoding: expected none, actual: %q", v)
+	}
+
+	if !strings.HasPrefix(res.Body.String(), `{"Manufacturer":"Porsche"`) {
+		t.Errorf("unexpected body: %s", res.Body)
+	}
+
+	// unsupported encodings are not negotiated
+	if res = send("/", "br"); res.Header().Get("Content-Encoding") != "" {
+		t.Error("compressed without an accepted encoding")
+	}
+}
+
+func TestCompressionContentTypes(t *testing.T) {
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 2048))
+
+		return nil
+	}, nil, &Config{Compression: &CompressionOptions{}})
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	q.ServeHTTP(recorder, r)
+
+	if v := recorder.Header().Get("Content-Encoding"); v != "" {
+		t.Errorf("compressed an ineligible content type: %q", v)
+	}
+
+	if l := recorder.Body.Len(); l != 2048 {
+		t.Errorf("body length: expected: 2048, actual: %d", l)
+	}
+}
+
+func TestCompressionFlush(t *testing.T) {
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("lap 1\n"))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("lap 2\n"))
+
+		return nil
+	}, nil, &Config{Compression: &CompressionOptions{}})
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	q.ServeHTTP(recorder, r)
+
+	if !recorder.Flushed {
+		t.Error("flush was not passed through")
+	}
+
+	zr, e := gzip.NewReader(recorder.Body)
+
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if b, _ := io.ReadAll(zr); string(b) != "lap 1\nlap 2\n" {
+		t.Errorf("unexpected body: %q", b)
+	}
+}
+
+func TestReadBodyGzip(t *testing.T) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"Model": "CLK GTR"}`))
+	zw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Encoding", "gzip")
+
+	b, e := ReadBody(r)
+
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	if string(b) != `{"Model": "CLK GTR"}` {
+		t.Errorf("unexpected body: %s", b)
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader("not gzip"))
+	r.Header.Set("Content-Encoding", "gzip")
+
+	_, e = ReadBody(r)
+
+	if he, ok := e.(HttpError); !ok || he.Code != http.StatusBadRequest {
+		t.Errorf("expected a 400 Bad Request error, actual: %v", e)
+	}
+}
+
+func TestReadBodyGzipBomb(t *testing.T) {
+	defer func(max int64) { MaxDecompressedSize = max }(MaxDecompressedSize)
+	MaxDecompressedSize = 1024
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(make([]byte, 1025))
+	zw.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/", &buf)
+	r.Header.Set("Content-Encoding", "gzip")
+
+	_, e := ReadBody(r)
+
+	if he, ok := e.(HttpError); !ok || he.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected a 413 Request Entity Too Large error, actual: %v", e)
+	}
+}
+
+func TestCompressionLevel(t *testing.T) {
+	defer func() {
+		if p := recover(); p == nil {
+			t.Error("expected NewServer to panic for an invalid level")
+		}
+	}()
+
+	NewServer(&Config{Compression: &CompressionOptions{Level: 10}})
+}
@@ -0,0 +1,199 @@
+package uf
+
+import (
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ConcurrencyLimiter configuration.
+type ConcurrencyOptions struct {
+	// Maximum number of requests in flight. In adaptive mode this is
+	// the upper bound the limit may grow to.
+	Limit int
+
+	// Maximum number of requests waiting for a slot once the limit is
+	// reached. Zero rejects requests immediately.
+	Queue int
+
+	// Maximum time a request waits in the queue. Zero waits until the
+	// request's context is done.
+	Wait time.Duration
+
+	// Sent as the Retry-After header when rejecting requests. Defaults to 1 second.
+	RetryAfter time.Duration
+
+	// Enables adaptive mode when non-zero. The limit is decreased while the
+	// observed latency exceeds Target and gradually increased while it does not.
+	Target time.Duration
+
+	// Lower bound of the limit in adaptive mode. Defaults to 1.
+	MinLimit int
+}
+
+// Limits the number of requests in flight, rejecting requests with a
+// 503 Service Unavailable error when saturated. Use as Config.ConcurrencyLimiter
+// to limit every route, or as middleware to limit a group or a single route.
+type ConcurrencyLimiter struct {
+	opts     ConcurrencyOptions
+	mutex    sync.Mutex
+	limit    float64
+	inFlight int
+	waiters  []chan struct{}
+	latency  time.Duration
+}
+
+// Create a new ConcurrencyLimiter.
+func NewConcurrencyLimiter(opts ConcurrencyOptions) *ConcurrencyLimiter {
+	if opts.RetryAfter <= 0 {
+		opts.RetryAfter = time.Second
+	}
+
+	if opts.MinLimit < 1 {
+		opts.MinLimit = 1
+	}
+
+	return &ConcurrencyLimiter{opts: opts, limit: float64(opts.Limit)}
+}
+
+// Number of requests currently being served.
+func (cl *ConcurrencyLimiter) InFlight() int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return cl.inFlight
+}
+
+// Number of requests currently waiting for a slot.
+func (cl *ConcurrencyLimiter) Waiting() int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return len(cl.waiters)
+}
+
+// Current limit. Only differs from the configured limit in adaptive mode.
+func (cl *ConcurrencyLimiter) Limit() int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	return int(cl.limit)
+}
+
+// Middleware limiting the routes it is applied to.
+func (cl *ConcurrencyLimiter) Middleware() Middleware {
+	return cl.acquire
+}
+
+// Wait for a slot and release it once the Queue serving r has finished.
+func (cl *ConcurrencyLimiter) acquire(r *http.Request) error {
+	cl.mutex.Lock()
+
+	if cl.inFlight < int(cl.limit) {
+		cl.inFlight++
+		cl.mutex.Unlock()
+
+		return cl.deferRelease(r)
+	}
+
+	if len(cl.waiters) >= cl.opts.Queue {
+		cl.mutex.Unlock()
+
+		return cl.reject()
+	}
+
+	ready := make(chan struct{})
+	cl.waiters = append(cl.waiters, ready)
+	cl.mutex.Unlock()
+
+	var timeout <-chan time.Time
+
+	if cl.opts.Wait > 0 {
+		timer := time.NewTimer(cl.opts.Wait)
+		defer timer.Stop()
+
+		timeout = timer.C
+	}
+
+	select {
+	case <-ready:
+		return cl.deferRelease(r)
+	case <-timeout:
+	case <-r.Context().Done():
+	}
+
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	for i, w := range cl.waiters {
+		if w == ready {
+			// still waiting so give up
+			cl.waiters = append(cl.waiters[:i], cl.waiters[i+1:]...)
+
+			return cl.reject()
+		}
+	}
+
+	// a slot was handed over while timing out; return it
+	cl.inFlight--
+	cl.handOver()
+
+	return cl.reject()
+}
+
+func (cl *ConcurrencyLimiter) deferRelease(r *http.Request) error {
+	start := time.Now()
+
+	if !Defer(r, func(error) { cl.release(time.Since(start)) }) {
+		// not served by a queue so the slot can never be released
+		cl.release(0)
+	}
+
+	return nil
+}
+
+// Release a slot after a request took latency to serve.
+func (cl *ConcurrencyLimiter) release(latency time.Duration) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+
+	cl.inFlight--
+
+	if cl.opts.Target > 0 && latency > 0 {
+		cl.adapt(latency)
+	}
+
+	cl.handOver()
+}
+
+// Additively increase the limit while latency is below the target and
+// multiplicatively decrease it while above.
+func (cl *ConcurrencyLimiter) adapt(latency time.Duration) {
+	if cl.latency == 0 {
+		cl.latency = latency
+	} else {
+		// exponentially weighted moving average
+		cl.latency = (4*cl.latency + latency) / 5
+	}
+
+	if cl.latency > cl.opts.Target && latency > cl.opts.Target {
+		cl.limit = math.Max(float64(cl.opts.MinLimit), cl.limit*0.9)
+	} else {
+		cl.limit = math.Min(float64(cl.opts.Limit), cl.limit+1/cl.limit)
+	}
+}
+
+// Hand free slots to waiting requests in arrival order.
+func (cl *ConcurrencyLimiter) handOver() {
+	for len(cl.waiters) > 0 && cl.inFlight < int(cl.limit) {
+		close(cl.waiters[0])
+		cl.waiters = cl.waiters[1:]
+		cl.inFlight++
+	}
+}
+
+func (cl *ConcurrencyLimiter) reject() error {
+	return ServiceUnavailable("Server is at capacity").
+		WithHeader("Retry-After", seconds(cl.opts.RetryAfter))
+}
@@ -0,0 +1,108 @@
+package uf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestConcurrencyLimiter(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{Limit: 1, Queue: 1, Wait: time.Second})
+	block := make(chan struct{})
+	started := make(chan struct{})
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		started <- struct{}{}
+		<-block
+
+		return nil
+	}, nil, &Config{ConcurrencyLimiter: cl})
+
+	codes := make(chan int, 3)
+	send := func() {
+		recorder := httptest.NewRecorder()
+		q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+		codes <- recorder.Code
+	}
+
+	go send()
+	<-started
+
+	if n := cl.InFlight(); n != 1 {
+		t.Fatalf("InFlight: expected: 1, actual: %d", n)
+	}
+
+	// the second request waits in the queue
+	go send()
+
+	for cl.Waiting() != 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the third is rejected because the queue is full
+	send()
+
+	if code := <-codes; code != http.StatusServiceUnavailable {
+		t.Fatalf("expected: 503, actual: %d", code)
+	}
+
+	// finishing the first request hands its slot to the waiting one
+	block <- struct{}{}
+	<-started
+	block <- struct{}{}
+
+	for i := 0; i < 2; i++ {
+		if code := <-codes; code != http.StatusOK {
+			t.Errorf("expected: 200, actual: %d", code)
+		}
+	}
+
+	if n := cl.InFlight(); n != 0 {
+		t.Errorf("InFlight: expected: 0, actual: %d", n)
+	}
+}
+
+func TestConcurrencyLimiterWait(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{Limit: 1, Queue: 1, Wait: time.Millisecond})
+	q := newQueue(handleNothing, []Middleware{cl.Middleware()}, &Config{})
+
+	// occupy the only slot
+	cl.inFlight = 1
+
+	recorder := httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected: 503, actual: %d", recorder.Code)
+	}
+
+	if v := recorder.Header().Get("Retry-After"); v != "1" {
+		t.Errorf("Retry-After: expected: 1, actual: %q", v)
+	}
+
+	if n := cl.Waiting(); n != 0 {
+		t.Errorf("Waiting: expected: 0, actual: %d", n)
+	}
+}
+
+func TestConcurrencyLimiterAdaptive(t *testing.T) {
+	cl := NewConcurrencyLimiter(ConcurrencyOptions{Limit: 10, Target: time.Millisecond, MinLimit: 2})
+
+	for i := 0; i < 50; i++ {
+		cl.inFlight++
+		cl.release(time.Second)
+	}
+
+	if l := cl.Limit(); l != 2 {
+		t.Fatalf("expected the limit to shrink to 2, actual: %d", l)
+	}
+
+	for i := 0; i < 500; i++ {
+		cl.inFlight++
+		cl.release(time.Microsecond)
+	}
+
+	if l := cl.Limit(); l != 10 {
+		t.Errorf("expected the limit to grow back to 10, actual: %d", l)
+	}
+}
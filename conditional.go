@@ -0,0 +1,287 @@
+package uf
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Format value as an entity tag. Eg. "v1" or W/"v1".
+func ETag(value string, weak bool) string {
+	if weak {
+		return `W/"` + value + `"`
+	}
+
+	return `"` + value + `"`
+}
+
+// Create a strong entity tag by hashing b.
+func HashETag(b []byte) string {
+	sum := sha256.Sum256(b)
+
+	return ETag(base64.RawURLEncoding.EncodeToString(sum[:16]), false)
+}
+
+// Set the ETag and (if non-zero) Last-Modified headers of the response, then
+// evaluate the request's If-None-Match and If-Modified-Since headers. If the
+// client's representation is current a 304 Not Modified response is sent and
+// true is returned, in which case the handler should return without writing a body.
+//
+// Handlers that can determine the version of a resource cheaply should call
+// this before doing any expensive work:
+//
+//	if uf.CheckNotModified(w, r, uf.ETag(book.Version, false), book.Updated) {
+//		return nil
+//	}
+//
+//	return uf.SendJSON(w, book)
+func CheckNotModified(w http.ResponseWriter, r *http.Request, etag string, modified time.Time) bool {
+	h := w.Header()
+
+	if etag != "" {
+		h.Set("ETag", etag)
+	}
+
+	if !modified.IsZero() {
+		h.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	}
+
+	if !notModified(r, h) {
+		return false
+	}
+
+	writeNotModified(w)
+
+	return true
+}
+
+// Functions implementing this type supply the current version of the resource
+// targeted by a request. An empty etag and zero modified time indicate the
+// resource does not exist.
+type VersionFunc func(*http.Request) (etag string, modified time.Time, e error)
+
+// Evaluate the request's If-Match, If-Unmodified-Since and (for methods other
+// than GET and HEAD) If-None-Match headers against the current version of the
+// resource. Returns a 412 Precondition Failed error if the client's
+// representation is stale. Per RFC 9110 section 13.2.2 If-Unmodified-Since is
+// ignored when If-Match is present, and when modified is zero. Handlers
+// should call this before modifying the resource:
+//
+//	if e := uf.CheckPreconditions(r, uf.ETag(book.Version, false), book.Updated); e != nil {
+//		return e
+//	}
+func CheckPreconditions(r *http.Request, etag string, modified time.Time) error {
+	if im := r.Header.Get("If-Match"); im != "" {
+		// strong comparison
+		if !matchETag(im, etag, false) {
+			return PreconditionFailed("If-Match does not match the current version")
+		}
+	} else if ius, e := http.ParseTime(r.Header.Get("If-Unmodified-Since")); e == nil {
+		// ignored for resources without a modification date (RFC 9110 section 13.1.4)
+		if !modified.IsZero() && modified.Truncate(time.Second).After(ius) {
+			return PreconditionFailed("Modified since " + ius.Format(http.TimeFormat))
+		}
+	}
+
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return nil
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchETag(inm, etag, true) {
+		return PreconditionFailed("If-None-Match matches the current version")
+	}
+
+	return nil
+}
+
+// Evaluate preconditions against the version of the resource supplied by
+// version before the handler is called. See CheckPreconditions.
+func Preconditions(version VersionFunc) Middleware {
+	return func(r *http.Request) error {
+		etag, modified, e := version(r)
+
+		if e != nil {
+			return e
+		}
+
+		return CheckPreconditions(r, etag, modified)
+	}
+}
+
+// Middleware rejecting PUT, PATCH and DELETE requests without an If-Match or
+// If-Unmodified-Since header with a 428 Precondition Required error. Apply to
+// routes where lost updates must be prevented.
+func RequirePreconditions(r *http.Request) error {
+	switch r.Method {
+	case http.MethodPut, http.MethodPatch, http.MethodDelete:
+		if r.Header.Get("If-Match") == "" && r.Header.Get("If-Unmodified-Since") == "" {
+			return PreconditionRequired("If-Match or If-Unmodified-Since is required")
+		}
+	}
+
+	return nil
+}
+
+// Determine whether the representation described by the ETag and Last-Modified
+// headers in h is current according to the conditional headers of a GET or HEAD
+// request. Per RFC 9110 section 13.2.2 If-Modified-Since is ignored when
+// If-None-Match is present.
+func notModified(r *http.Request, h http.Header) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		// weak comparison
+		return matchETag(inm, h.Get("ETag"), true)
+	}
+
+	ims, e := http.ParseTime(r.Header.Get("If-Modified-Since"))
+
+	if e != nil {
+		return false
+	}
+
+	modified, e := http.ParseTime(h.Get("Last-Modified"))
+
+	if e != nil {
+		return false
+	}
+
+	return !modified.After(ims)
+}
+
+// Determine whether etag matches any entity tag in list. A list of "*"
+// matches any current representation. Weak comparison ignores the weakness
+// indicator; strong comparison requires both tags to be strong.
+func matchETag(list, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+
+	if strings.TrimSpace(list) == "*" {
+		return true
+	}
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+
+		if weak {
+			if strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		} else if candidate == etag && !strings.HasPrefix(etag, "W/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Send a 304 Not Modified response, removing headers describing the body.
+func writeNotModified(w http.ResponseWriter) {
+	h := w.Header()
+	h.Del("Content-Type")
+	h.Del("Content-Length")
+	h.Del("Content-Encoding")
+
+	if h.Get("ETag") != "" {
+		// RFC 9110 section 15.4.5: the ETag is sufficient
+		h.Del("Last-Modified")
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// Buffers successful GET and HEAD responses in order to generate an ETag
+// and answer conditional requests once the handler has finished.
+type etagWriter struct {
+	http.ResponseWriter
+	r           *http.Request
+	cw          *compressWriter // the writer wrapped if compressing; nil otherwise
+	buf         bytes.Buffer
+	code        int
+	passthrough bool
+}
+
+func (ew *etagWriter) WriteHeader(code int) {
+	if ew.code != 0 || ew.passthrough {
+		return
+	}
+
+	ew.code = code
+
+	if code != http.StatusOK {
+		// only successful responses are tagged
+		ew.passthrough = true
+		ew.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (ew *etagWriter) Write(b []byte) (int, error) {
+	if ew.code == 0 {
+		ew.code = http.StatusOK
+	}
+
+	if ew.passthrough {
+		return ew.ResponseWriter.Write(b)
+	}
+
+	return ew.buf.Write(b)
+}
+
+// Flush implements http.Flusher. A streamed response cannot be hashed
+// so the buffered body is sent untagged.
+func (ew *etagWriter) Flush() {
+	if !ew.passthrough {
+		ew.passthrough = true
+
+		if ew.code != 0 {
+			ew.ResponseWriter.WriteHeader(ew.code)
+			ew.ResponseWriter.Write(ew.buf.Bytes())
+			ew.buf.Reset()
+		}
+	}
+
+	if f, ok := ew.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Tag and send the buffered response, or send 304 Not Modified.
+func (ew *etagWriter) finish() {
+	if ew.passthrough || ew.code == 0 {
+		return
+	}
+
+	h := ew.Header()
+
+	if h.Get("ETag") == "" {
+		body := ew.buf.Bytes()
+		tag := HashETag(body)
+
+		if ew.cw != nil && ew.cw.eligible(ew.code, body, len(body) >= ew.cw.opts.MinSize) {
+			// the encoded representation needs its own strong tag (RFC 9110 section 8.8.3)
+			tag = tag[:len(tag)-1] + "-" + ew.cw.encoding + `"`
+		}
+
+		h.Set("ETag", tag)
+	}
+
+	if notModified(ew.r, h) {
+		writeNotModified(ew.ResponseWriter)
+
+		return
+	}
+
+	ew.ResponseWriter.WriteHeader(ew.code)
+	ew.ResponseWriter.Write(ew.buf.Bytes())
+}
+
+// Allows http.ResponseController to reach the underlying writer.
+func (ew *etagWriter) Unwrap() http.ResponseWriter {
+	return ew.ResponseWriter
+}
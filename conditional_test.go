@@ -0,0 +1,250 @@
+package uf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchETag(t *testing.T) {
+	cases := []struct {
+		list, etag   string
+		weak, expect bool
+	}{
+		{`"a"`, `"a"`, false, true},
+		{`"a", "b"`, `"b"`, false, true},
+		{`W/"a"`, `"a"`, false, false},
+		{`W/"a"`, `"a"`, true, true},
+		{`"a"`, `W/"a"`, false, false},
+		{`*`, `"a"`, false, true},
+		{`*`, ``, true, false},
+		{`"b"`, `"a"`, true, false},
+	}
+
+	for _, c := range cases {
+		if actual := matchETag(c.list, c.etag, c.weak); actual != c.expect {
+			t.Errorf("%+v: actual: %t", c, actual)
+		}
+	}
+}
+
+func TestAutomaticETags(t *testing.T) {
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, GT1{"Nissan", "R390 LM", 1997})
+	}, nil, &Config{ETags: true})
+
+	recorder := httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	etag := recorder.Header().Get("ETag")
+
+	if recorder.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected a tagged 200 response: %d %q", recorder.Code, etag)
+	}
+
+	// the same representation is not modified
+	recorder = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"other", `+etag)
+	q.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusNotModified {
+		t.Fatalf("expected: 304, actual: %d", recorder.Code)
+	}
+
+	if recorder.Body.Len() != 0 || recorder.Header().Get("Content-Type") != "" {
+		t.Errorf("304 response described a body: %v %s", recorder.Header(), recorder.Body)
+	}
+
+	// a different representation is sent in full
+	recorder = httptest.NewRecorder()
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"other"`)
+	q.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusOK || recorder.Body.Len() == 0 {
+		t.Errorf("expected a 200 response with a body: %d", recorder.Code)
+	}
+}
+
+func TestAutomaticETagsCompressed(t *testing.T) {
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, GT1{"Nissan", "R390 LM", 1997})
+	}, nil, &Config{ETags: true, Compression: &CompressionOptions{MinSize: 1}})
+
+	send := func(encoding, inm string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		r.Header.Set("If-None-Match", inm)
+		q.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	identity := send("", "").Header().Get("ETag")
+	gzipped := send("gzip", "")
+
+	if gzipped.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected a gzip response: %v", gzipped.Header())
+	}
+
+	if tag := gzipped.Header().Get("ETag"); tag == identity || !strings.HasSuffix(tag, `-gzip"`) {
+		t.Errorf("encodings must have distinct strong tags: %s %s", identity, tag)
+	}
+
+	if code := send("gzip", gzipped.Header().Get("ETag")).Code; code != http.StatusNotModified {
+		t.Errorf("expected: 304, actual: %d", code)
+	}
+
+	if code := send("gzip", identity).Code; code != http.StatusOK {
+		t.Errorf("the identity tag must not match the gzip representation: %d", code)
+	}
+}
+
+func TestCheckNotModified(t *testing.T) {
+	modified := time.Date(1998, time.June, 7, 12, 0, 0, 0, time.UTC)
+	calls := 0
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		if CheckNotModified(w, r, ETag("v1", true), modified) {
+			return nil
+		}
+
+		calls++
+
+		return SendJSON(w, GT1{"Toyota", "GT-One", 1998})
+	}
+
+	cases := []struct {
+		header, value string
+		code          int
+	}{
+		{"If-None-Match", `W/"v1"`, http.StatusNotModified},
+		{"If-None-Match", `"v1"`, http.StatusNotModified},
+		{"If-None-Match", `"v2"`, http.StatusOK},
+		{"If-Modified-Since", modified.Format(http.TimeFormat), http.StatusNotModified},
+		{"If-Modified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusOK},
+	}
+
+	for _, c := range cases {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(c.header, c.value)
+		NewHttpTestHandler(h).ServeHTTP(recorder, r)
+
+		if recorder.Code != c.code {
+			t.Errorf("%s: %s: expected: %d, actual: %d", c.header, c.value, c.code, recorder.Code)
+		}
+	}
+
+	// If-None-Match takes precedence over If-Modified-Since
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", `"v2"`)
+	r.Header.Set("If-Modified-Since", modified.Format(http.TimeFormat))
+	NewHttpTestHandler(h).ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("If-Modified-Since was not ignored: %d", recorder.Code)
+	}
+
+	if calls != 3 {
+		t.Errorf("handler did not short-circuit: %d calls", calls)
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(1999, time.June, 13, 15, 0, 0, 0, time.UTC)
+	etag := ETag("v2", false)
+	cases := []struct {
+		method, header, value string
+		code                  int
+	}{
+		{http.MethodPut, "If-Match", etag, 0},
+		{http.MethodPut, "If-Match", `"v1"`, http.StatusPreconditionFailed},
+		{http.MethodPut, "If-Match", `W/"v2"`, http.StatusPreconditionFailed},
+		{http.MethodDelete, "If-Match", "*", 0},
+		{http.MethodPatch, "If-Unmodified-Since", modified.Format(http.TimeFormat), 0},
+		{http.MethodPatch, "If-Unmodified-Since", modified.Add(-time.Hour).Format(http.TimeFormat), http.StatusPreconditionFailed},
+		{http.MethodPut, "If-None-Match", "*", http.StatusPreconditionFailed},
+		{http.MethodGet, "If-None-Match", "*", 0},
+	}
+
+	for _, c := range cases {
+		r := httptest.NewRequest(c.method, "/", nil)
+		r.Header.Set(c.header, c.value)
+		e := CheckPreconditions(r, etag, modified)
+
+		if c.code == 0 {
+			if e != nil {
+				t.Errorf("%+v: unexpected error: %v", c, e)
+			}
+		} else if he, ok := e.(HttpError); !ok || he.Code != c.code {
+			t.Errorf("%+v: expected: %d, actual: %v", c, c.code, e)
+		}
+	}
+
+	// If-Unmodified-Since is ignored when If-Match is present
+	r := httptest.NewRequest(http.MethodPut, "/", nil)
+	r.Header.Set("If-Match", etag)
+	r.Header.Set("If-Unmodified-Since", modified.Add(-time.Hour).Format(http.TimeFormat))
+
+	if e := CheckPreconditions(r, etag, modified); e != nil {
+		t.Errorf("If-Unmodified-Since was not ignored: %v", e)
+	}
+
+	// resources with only an ETag have no modification date to compare
+	r = httptest.NewRequest(http.MethodPut, "/", nil)
+	r.Header.Set("If-Unmodified-Since", modified.Format(http.TimeFormat))
+
+	if e := CheckPreconditions(r, etag, time.Time{}); e != nil {
+		t.Errorf("If-Unmodified-Since was not ignored without a modification date: %v", e)
+	}
+
+	// a missing resource never matches
+	r = httptest.NewRequest(http.MethodPut, "/", nil)
+	r.Header.Set("If-Match", "*")
+
+	if e := CheckPreconditions(r, "", time.Time{}); e == nil {
+		t.Error("If-Match: * matched a missing resource")
+	}
+}
+
+func TestPreconditionsMiddleware(t *testing.T) {
+	version := func(r *http.Request) (string, time.Time, error) {
+		return ETag("v3", false), time.Time{}, nil
+	}
+
+	q := newQueue(handleNothing, []Middleware{RequirePreconditions, Preconditions(version)}, &Config{})
+
+	send := func(method, ifMatch string) int {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(method, "/", nil)
+
+		if ifMatch != "" {
+			r.Header.Set("If-Match", ifMatch)
+		}
+
+		q.ServeHTTP(recorder, r)
+
+		return recorder.Code
+	}
+
+	if code := send(http.MethodPut, ""); code != http.StatusPreconditionRequired {
+		t.Errorf("expected: 428, actual: %d", code)
+	}
+
+	if code := send(http.MethodPut, `"v2"`); code != http.StatusPreconditionFailed {
+		t.Errorf("expected: 412, actual: %d", code)
+	}
+
+	if code := send(http.MethodPut, `"v3"`); code != http.StatusOK {
+		t.Errorf("expected: 200, actual: %d", code)
+	}
+
+	if code := send(http.MethodGet, ""); code != http.StatusOK {
+		t.Errorf("expected: 200, actual: %d", code)
+	}
+}
@@ -0,0 +1,262 @@
+package uf
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// Dependency injection container holding the providers of values by type.
+// Every server has one (see Server.Container); values are resolved from
+// middleware and handlers with Resolve:
+//
+//	uf.ProvideSingleton(server.Container(), func() (*sql.DB, error) {
+//		return sql.Open("postgres", dsn)
+//	})
+//
+//	uf.ProvideScoped(server.Container(), func(r *http.Request) (*Session, error) {
+//		db, e := uf.Resolve[*sql.DB](r)
+//
+//		// ...
+//	})
+//
+//	func handler(w http.ResponseWriter, r *http.Request) error {
+//		session, e := uf.Resolve[*Session](r)
+//
+//		// ...
+//	}
+type Container struct {
+	mu        sync.RWMutex
+	providers map[reflect.Type]*provider
+}
+
+type provider struct {
+	// one value per request rather than one shared by every request
+	scoped bool
+	build  func(*http.Request) (interface{}, error)
+
+	// singletons
+	mu    sync.Mutex
+	built bool
+	value interface{}
+}
+
+// Create an empty container.
+func NewContainer() *Container {
+	return &Container{providers: make(map[reflect.Type]*provider)}
+}
+
+// The type T as a map key. Interface types are preserved.
+func typeOf[T any]() reflect.Type {
+	return reflect.TypeOf((*T)(nil)).Elem()
+}
+
+// Register build as the provider of the value of type T shared by every
+// request, replacing any previous provider. The value is built on first use;
+// a failed build is attempted again by the next request.
+func ProvideSingleton[T any](c *Container, build func() (T, error)) {
+	c.set(typeOf[T](), &provider{
+		build: func(*http.Request) (interface{}, error) { return build() },
+	})
+}
+
+// Register build as the provider of per-request values of type T, replacing
+// any previous provider. The value is built on first use during a request
+// and closed once the Queue serving the request has finished if it
+// implements io.Closer.
+func ProvideScoped[T any](c *Container, build func(*http.Request) (T, error)) {
+	c.set(typeOf[T](), &provider{
+		scoped: true,
+		build:  func(r *http.Request) (interface{}, error) { return build(r) },
+	})
+}
+
+// Register v as the value of type T shared by every request, replacing any
+// previous provider.
+func ProvideValue[T any](c *Container, v T) {
+	c.set(typeOf[T](), &provider{built: true, value: v})
+}
+
+// Replace the provider of T with v until the returned function is called.
+// Intended for tests:
+//
+//	defer uf.Override[Mailer](server.Container(), &fakeMailer{})()
+func Override[T any](c *Container, v T) func() {
+	t := typeOf[T]()
+
+	c.mu.RLock()
+	previous, ok := c.providers[t]
+	c.mu.RUnlock()
+
+	ProvideValue(c, v)
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		if ok {
+			c.providers[t] = previous
+		} else {
+			delete(c.providers, t)
+		}
+	}
+}
+
+func (c *Container) set(t reflect.Type, p *provider) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.providers[t] = p
+}
+
+func (c *Container) get(t reflect.Type) *provider {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return c.providers[t]
+}
+
+// Close every singleton built so far that implements io.Closer, returning
+// the first error. Call when shutting down the server.
+func (c *Container) Close() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var first error
+
+	for _, p := range c.providers {
+		if p.scoped || p.build == nil {
+			// values are owned by the caller
+			continue
+		}
+
+		p.mu.Lock()
+
+		if closer, ok := p.value.(io.Closer); ok && p.built {
+			if e := closer.Close(); e != nil && first == nil {
+				first = e
+			}
+		}
+
+		p.built = false
+		p.value = nil
+		p.mu.Unlock()
+	}
+
+	return first
+}
+
+// Get the value of type T for the request r from the container of the
+// server serving it. Returns an error if no provider of T was registered,
+// if building the value failed, or if r is not being served by a Queue.
+func Resolve[T any](r *http.Request) (T, error) {
+	var zero T
+	t := typeOf[T]()
+	s := stateFrom(r)
+
+	if s == nil || s.services == nil {
+		return zero, fmt.Errorf("uf: cannot resolve %v: request is not served by a server", t)
+	}
+
+	v, e := s.resolve(t, r)
+
+	if e != nil {
+		return zero, e
+	}
+
+	return v.(T), nil
+}
+
+// Get the value of type T for the request r. Panics if it cannot be resolved.
+func MustResolve[T any](r *http.Request) T {
+	v, e := Resolve[T](r)
+
+	if e != nil {
+		panic(e)
+	}
+
+	return v
+}
+
+// Resolve a value of type t for the request served with the state s.
+func (s *state) resolve(t reflect.Type, r *http.Request) (interface{}, error) {
+	p := s.services.get(t)
+
+	if p == nil {
+		return nil, fmt.Errorf("uf: no provider of %v", t)
+	}
+
+	if !p.scoped {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		if !p.built {
+			v, e := p.build(r)
+
+			if e != nil {
+				return nil, e
+			}
+
+			p.value, p.built = v, true
+		}
+
+		return p.value, nil
+	}
+
+	s.mu.Lock()
+	v, ok := s.scoped[p]
+	s.mu.Unlock()
+
+	if ok {
+		return v, nil
+	}
+
+	// build without holding the lock as providers may resolve other values
+	v, e := p.build(r)
+
+	if e != nil {
+		return nil, e
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if existing, ok := s.scoped[p]; ok {
+		// built concurrently by another goroutine of the same request
+		s.disposing = append(s.disposing, v)
+
+		return existing, nil
+	}
+
+	if s.scoped == nil {
+		s.scoped = make(map[*provider]interface{})
+	}
+
+	s.scoped[p] = v
+	s.disposing = append(s.disposing, v)
+
+	return v, nil
+}
+
+// Close the scoped values of the request in reverse order of creation,
+// returning the first error.
+func (s *state) dispose() error {
+	s.mu.Lock()
+	values := s.disposing
+	s.disposing = nil
+	s.mu.Unlock()
+
+	var first error
+
+	for i := len(values) - 1; i >= 0; i-- {
+		if closer, ok := values[i].(io.Closer); ok {
+			if e := closer.Close(); e != nil && first == nil {
+				first = e
+			}
+		}
+	}
+
+	return first
+}
@@ -0,0 +1,153 @@
+package uf
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+type pitCrew struct {
+	closed *int32
+}
+
+func (pc *pitCrew) Close() error {
+	atomic.AddInt32(pc.closed, 1)
+
+	return nil
+}
+
+type Mechanic interface {
+	Name() string
+}
+
+type mechanic string
+
+func (m mechanic) Name() string {
+	return string(m)
+}
+
+func TestContainer(t *testing.T) {
+	s := NewServer(&Config{})
+	var built, closed, served int32
+
+	ProvideSingleton(s.Container(), func() (*GT1, error) {
+		atomic.AddInt32(&built, 1)
+
+		return &GT1{"Porsche", "911 GT1", 1996}, nil
+	})
+
+	ProvideScoped(s.Container(), func(r *http.Request) (*pitCrew, error) {
+		// scoped providers may resolve other values
+		if _, e := Resolve[*GT1](r); e != nil {
+			return nil, e
+		}
+
+		return &pitCrew{&closed}, nil
+	})
+
+	ProvideValue[Mechanic](s.Container(), mechanic("Norbert"))
+
+	s.Get("/", func(w http.ResponseWriter, r *http.Request) error {
+		crew := MustResolve[*pitCrew](r)
+
+		if again := MustResolve[*pitCrew](r); again != crew {
+			t.Error("scoped value was built twice in one request")
+		}
+
+		// values of earlier requests have been closed
+		if atomic.LoadInt32(&closed) != served {
+			t.Error("scoped value was closed during the request")
+		}
+
+		if m := MustResolve[Mechanic](r); m.Name() != "Norbert" {
+			t.Errorf("unexpected value: %v", m)
+		}
+
+		if _, e := Resolve[*Character](r); e == nil {
+			t.Error("expected an error for a type without a provider")
+		}
+
+		served++
+
+		return nil
+	})
+
+	for i := 0; i < 3; i++ {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	if built != 1 {
+		t.Errorf("singleton was built %d times", built)
+	}
+
+	if closed != 3 {
+		t.Errorf("scoped values were closed %d times", closed)
+	}
+}
+
+func TestContainerOverride(t *testing.T) {
+	s := NewServer(&Config{})
+	ProvideValue[Mechanic](s.Container(), mechanic("Norbert"))
+
+	var name string
+	s.Get("/", func(w http.ResponseWriter, r *http.Request) error {
+		name = MustResolve[Mechanic](r).Name()
+
+		return nil
+	})
+
+	restore := Override[Mechanic](s.Container(), mechanic("Hans"))
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if name != "Hans" {
+		t.Errorf("override was not used: %s", name)
+	}
+
+	restore()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if name != "Norbert" {
+		t.Errorf("override was not restored: %s", name)
+	}
+}
+
+func TestContainerErrors(t *testing.T) {
+	s := NewServer(&Config{})
+	fail := true
+
+	ProvideSingleton(s.Container(), func() (*GT1, error) {
+		if fail {
+			return nil, errors.New("garage closed")
+		}
+
+		return &GT1{}, nil
+	})
+
+	s.Get("/", func(w http.ResponseWriter, r *http.Request) error {
+		_, e := Resolve[*GT1](r)
+
+		return e
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("expected 500, actual: %d", recorder.Code)
+	}
+
+	// failed singletons are built again
+	fail = false
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("expected 200, actual: %d", recorder.Code)
+	}
+
+	if _, e := Resolve[*GT1](httptest.NewRequest(http.MethodGet, "/", nil)); e == nil {
+		t.Error("expected an error outside of a server")
+	}
+}
@@ -0,0 +1,344 @@
+package uf
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	rpprof "runtime/pprof"
+	rtrace "runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Runtime statistics sent by the stats endpoint of MountDebug.
+type RuntimeStats struct {
+	Goroutines int    `json:"goroutines"`
+	CPUs       int    `json:"cpus"`
+	GoVersion  string `json:"goVersion"`
+	Uptime     string `json:"uptime"`
+
+	Heap struct {
+		Alloc    uint64 `json:"alloc"`
+		Sys      uint64 `json:"sys"`
+		Idle     uint64 `json:"idle"`
+		InUse    uint64 `json:"inUse"`
+		Released uint64 `json:"released"`
+		Objects  uint64 `json:"objects"`
+	} `json:"heap"`
+
+	GC struct {
+		Count      uint32    `json:"count"`
+		Last       time.Time `json:"last"`
+		PauseTotal string    `json:"pauseTotal"`
+		NextHeap   uint64    `json:"nextHeap"`
+	} `json:"gc"`
+
+	// Cumulative bytes allocated and objects allocated and freed.
+	TotalAlloc uint64 `json:"totalAlloc"`
+	Mallocs    uint64 `json:"mallocs"`
+	Frees      uint64 `json:"frees"`
+}
+
+// The build information of the binary sent by the build endpoint of MountDebug.
+type BuildInfo struct {
+	GoVersion string            `json:"goVersion"`
+	Path      string            `json:"path"`
+	Main      ModuleInfo        `json:"main"`
+	Deps      []ModuleInfo      `json:"deps"`
+	Settings  map[string]string `json:"settings"`
+}
+
+// A module of BuildInfo.
+type ModuleInfo struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Sum     string `json:"sum,omitempty"`
+}
+
+var started = time.Now()
+
+// Bind debugging endpoints under prefix for GET requests, passing through
+// the global middleware, auth and m. The endpoints disclose the command line
+// and internals of the process so auth, which must authenticate the client,
+// is required; MountDebug panics if it is nil:
+//
+//	prefix/pprof/       the pprof index and profiles, built on runtime/pprof
+//	prefix/stats        goroutine, heap and GC statistics (RuntimeStats)
+//	prefix/build        the build information of the binary (BuildInfo)
+//	prefix/routes       the route table (see Server.HandleRoutes)
+//
+// Eg. server.MountDebug("/debug", requireAdmin). The profile endpoints are
+// not subject to Config.Timeout as CPU profiles and traces take as long as
+// the seconds parameter requests.
+func (s *Server) MountDebug(prefix string, auth Middleware, m ...Middleware) {
+	if auth == nil {
+		panic("uf: MountDebug requires an authentication middleware")
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+	m = append([]Middleware{auth}, m...)
+
+	// the pprof symbol endpoint also accepts POST
+	s.Get(prefix+"/pprof/*profile", handlePprof, m...).Timeout(-1)
+	s.Post(prefix+"/pprof/*profile", handlePprof, m...).Timeout(-1)
+	s.Get(prefix+"/stats", handleStats, m...)
+	s.Get(prefix+"/build", handleBuild, m...)
+	s.Get(prefix+"/routes", s.HandleRoutes, m...)
+}
+
+// Serve the pprof index or the profile named by the profile parameter. The
+// handlers are built on runtime/pprof rather than net/http/pprof, which
+// registers them on http.DefaultServeMux as a side effect of being imported.
+func handlePprof(w http.ResponseWriter, r *http.Request) error {
+	name := strings.TrimPrefix(GetParam(r, "profile"), "/")
+
+	if r.Method == http.MethodPost && name != "symbol" {
+		return MethodNotAllowed("Only the symbol endpoint accepts POST")
+	}
+
+	switch name {
+	case "":
+		return pprofIndex(w, r)
+	case "cmdline":
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		_, e := io.WriteString(w, strings.Join(os.Args, "\x00"))
+
+		return e
+	case "profile":
+		return pprofCPU(w, r)
+	case "symbol":
+		return pprofSymbol(w, r)
+	case "trace":
+		return pprofTrace(w, r)
+	}
+
+	p := rpprof.Lookup(name)
+
+	if p == nil {
+		return NotFound("Unknown profile: " + name)
+	}
+
+	level, _ := strconv.Atoi(r.URL.Query().Get("debug"))
+
+	if name == "heap" && r.URL.Query().Get("gc") != "" {
+		runtime.GC()
+	}
+
+	if level == 0 {
+		pprofAttachment(w, name)
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	return p.WriteTo(w, level)
+}
+
+// The profiles listed by the index besides those of runtime/pprof.
+var pprofEndpoints = []string{"cmdline", "profile", "symbol", "trace"}
+
+func pprofIndex(w http.ResponseWriter, r *http.Request) error {
+	var b strings.Builder
+
+	b.WriteString("<html><head><title>pprof</title></head><body>\n<h1>Profiles</h1>\n<table>\n")
+
+	// links are relative so work under any prefix
+	for _, p := range rpprof.Profiles() {
+		fmt.Fprintf(&b, "<tr><td>%d</td><td><a href=\"%s?debug=1\">%[2]s</a></td></tr>\n", p.Count(), html.EscapeString(p.Name()))
+	}
+
+	for _, name := range pprofEndpoints {
+		fmt.Fprintf(&b, "<tr><td></td><td><a href=\"%s\">%[1]s</a></td></tr>\n", name)
+	}
+
+	b.WriteString("</table>\n</body></html>\n")
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, e := io.WriteString(w, b.String())
+
+	return e
+}
+
+// Profile the CPU for the number of seconds requested (30 by default).
+func pprofCPU(w http.ResponseWriter, r *http.Request) error {
+	d, e := pprofSeconds(r, 30)
+
+	if e != nil {
+		return e
+	}
+
+	// the profile is written as it is collected so set the headers first
+	pprofAttachment(w, "profile")
+
+	if e := rpprof.StartCPUProfile(w); e != nil {
+		w.Header().Del("Content-Disposition")
+
+		return InternalServerError("Could not enable CPU profiling: " + e.Error())
+	}
+
+	pprofSleep(r, d)
+	rpprof.StopCPUProfile()
+
+	return nil
+}
+
+// Trace execution for the number of seconds requested (1 by default).
+func pprofTrace(w http.ResponseWriter, r *http.Request) error {
+	d, e := pprofSeconds(r, 1)
+
+	if e != nil {
+		return e
+	}
+
+	pprofAttachment(w, "trace")
+
+	if e := rtrace.Start(w); e != nil {
+		w.Header().Del("Content-Disposition")
+
+		return InternalServerError("Could not enable tracing: " + e.Error())
+	}
+
+	pprofSleep(r, d)
+	rtrace.Stop()
+
+	return nil
+}
+
+// Look up the functions of the program counters in the query string or body
+// separated by +, in the format expected by the pprof tool.
+func pprofSymbol(w http.ResponseWriter, r *http.Request) error {
+	pcs := r.URL.RawQuery
+
+	if r.Method == http.MethodPost {
+		body, e := io.ReadAll(r.Body)
+
+		if e != nil {
+			return e
+		}
+
+		pcs = string(body)
+	}
+
+	var b strings.Builder
+
+	// the pprof tool only checks that symbols are available
+	b.WriteString("num_symbols: 1\n")
+
+	for _, word := range strings.Split(pcs, "+") {
+		pc, e := strconv.ParseUint(word, 0, 64)
+
+		if e != nil {
+			continue
+		}
+
+		if f := runtime.FuncForPC(uintptr(pc)); f != nil {
+			fmt.Fprintf(&b, "%#x %s\n", pc, f.Name())
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	_, e := io.WriteString(w, b.String())
+
+	return e
+}
+
+func pprofAttachment(w http.ResponseWriter, name string) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+}
+
+// Get the seconds parameter, or def if it is absent.
+func pprofSeconds(r *http.Request, def int) (time.Duration, error) {
+	v := r.URL.Query().Get("seconds")
+
+	if v == "" {
+		return time.Duration(def) * time.Second, nil
+	}
+
+	s, e := strconv.Atoi(v)
+
+	if e != nil || s <= 0 {
+		return 0, BadRequest("seconds must be a positive integer")
+	}
+
+	return time.Duration(s) * time.Second, nil
+}
+
+// Sleep for d or until the client goes away.
+func pprofSleep(r *http.Request, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-r.Context().Done():
+	}
+}
+
+func handleStats(w http.ResponseWriter, r *http.Request) error {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	stats := RuntimeStats{
+		Goroutines: runtime.NumGoroutine(),
+		CPUs:       runtime.NumCPU(),
+		GoVersion:  runtime.Version(),
+		Uptime:     time.Since(started).Round(time.Second).String(),
+		TotalAlloc: ms.TotalAlloc,
+		Mallocs:    ms.Mallocs,
+		Frees:      ms.Frees,
+	}
+
+	stats.Heap.Alloc = ms.HeapAlloc
+	stats.Heap.Sys = ms.HeapSys
+	stats.Heap.Idle = ms.HeapIdle
+	stats.Heap.InUse = ms.HeapInuse
+	stats.Heap.Released = ms.HeapReleased
+	stats.Heap.Objects = ms.HeapObjects
+	stats.GC.Count = ms.NumGC
+	stats.GC.PauseTotal = time.Duration(ms.PauseTotalNs).String()
+	stats.GC.NextHeap = ms.NextGC
+
+	if ms.LastGC > 0 {
+		stats.GC.Last = time.Unix(0, int64(ms.LastGC)).UTC()
+	}
+
+	return SendJSON(w, stats)
+}
+
+func handleBuild(w http.ResponseWriter, r *http.Request) error {
+	bi, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return NotFound("Build information is not available")
+	}
+
+	info := BuildInfo{
+		GoVersion: bi.GoVersion,
+		Path:      bi.Path,
+		Main:      ModuleInfo{bi.Main.Path, bi.Main.Version, bi.Main.Sum},
+		Deps:      make([]ModuleInfo, 0, len(bi.Deps)),
+		Settings:  make(map[string]string, len(bi.Settings)),
+	}
+
+	for _, dep := range bi.Deps {
+		mi := ModuleInfo{dep.Path, dep.Version, dep.Sum}
+
+		if dep.Replace != nil {
+			// the version actually built
+			mi.Version, mi.Sum = dep.Replace.Version, dep.Replace.Sum
+		}
+
+		info.Deps = append(info.Deps, mi)
+	}
+
+	for _, setting := range bi.Settings {
+		info.Settings[setting.Key] = setting.Value
+	}
+
+	return SendJSON(w, info)
+}
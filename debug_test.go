@@ -0,0 +1,136 @@
+package uf
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requireAdmin(r *http.Request) error {
+	if r.Header.Get("Authorization") != "admin" {
+		return Unauthorized("Not an admin")
+	}
+
+	return nil
+}
+
+func TestMountDebug(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Get("/car", handleNothing)
+	s.MountDebug("/debug/", requireAdmin)
+
+	get := func(path string, admin bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+
+		if admin {
+			r.Header.Set("Authorization", "admin")
+		}
+
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	for _, path := range []string{"/debug/pprof/", "/debug/pprof/heap", "/debug/stats", "/debug/build", "/debug/routes"} {
+		if recorder := get(path, false); recorder.Code != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401; got %d", path, recorder.Code)
+		}
+
+		if recorder := get(path, true); recorder.Code != http.StatusOK {
+			t.Errorf("%s: expected 200; got %d", path, recorder.Code)
+		}
+	}
+
+	if body := get("/debug/pprof/", true).Body.String(); !strings.Contains(body, "goroutine?debug=1") {
+		t.Errorf("unexpected index: %s", body)
+	}
+
+	if body := get("/debug/pprof/goroutine?debug=1", true).Body.String(); !strings.Contains(body, "goroutine profile") {
+		t.Errorf("unexpected profile: %s", body)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("MountDebug must require an authentication middleware")
+			}
+		}()
+
+		s.MountDebug("/unauthenticated", nil)
+	}()
+
+	recorder := get("/debug/pprof/gremlins", true)
+	var he HttpError
+
+	if e := json.Unmarshal(recorder.Body.Bytes(), &he); e != nil || recorder.Code != http.StatusNotFound {
+		t.Errorf("unknown profiles must be JSON errors: %d %s", recorder.Code, recorder.Body)
+	}
+
+	var stats RuntimeStats
+
+	if e := json.Unmarshal(get("/debug/stats", true).Body.Bytes(), &stats); e != nil || stats.Goroutines == 0 || stats.Heap.Alloc == 0 {
+		t.Errorf("unexpected stats: %v %+v", e, stats)
+	}
+
+	var routes []RouteInfo
+
+	if e := json.Unmarshal(get("/debug/routes", true).Body.Bytes(), &routes); e != nil || routes[0].Path != "/car" {
+		t.Errorf("unexpected routes: %v %+v", e, routes)
+	}
+}
+
+func TestPprof(t *testing.T) {
+	s := NewServer(&Config{})
+	s.MountDebug("/debug", requireAdmin)
+
+	serve := func(r *http.Request) *httptest.ResponseRecorder {
+		r.Header.Set("Authorization", "admin")
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, r)
+
+		return recorder
+	}
+
+	// nothing is registered on the default mux as a side effect
+	if _, pattern := http.DefaultServeMux.Handler(httptest.NewRequest(http.MethodGet, "/debug/pprof/", nil)); pattern != "" {
+		t.Errorf("pprof handlers registered on http.DefaultServeMux: %q", pattern)
+	}
+
+	if body := serve(httptest.NewRequest(http.MethodGet, "/debug/pprof/cmdline", nil)).Body.String(); !strings.HasPrefix(body, os.Args[0]) {
+		t.Errorf("unexpected cmdline: %q", body)
+	}
+
+	pc := reflect.ValueOf(TestPprof).Pointer()
+	body := serve(httptest.NewRequest(http.MethodPost, "/debug/pprof/symbol", strings.NewReader(fmt.Sprintf("%#x", pc)))).Body.String()
+
+	if !strings.Contains(body, "num_symbols: 1") || !strings.Contains(body, "TestPprof") {
+		t.Errorf("unexpected symbols: %q", body)
+	}
+
+	if recorder := serve(httptest.NewRequest(http.MethodPost, "/debug/pprof/heap", nil)); recorder.Code != http.StatusMethodNotAllowed {
+		t.Errorf("expected 405; got %d", recorder.Code)
+	}
+
+	if recorder := serve(httptest.NewRequest(http.MethodGet, "/debug/pprof/profile?seconds=soon", nil)); recorder.Code != http.StatusBadRequest {
+		t.Errorf("expected 400; got %d", recorder.Code)
+	}
+
+	// profiling ends early when the client goes away
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	recorder := serve(httptest.NewRequest(http.MethodGet, "/debug/pprof/profile", nil).WithContext(ctx))
+
+	// profiles are gzipped
+	if body := recorder.Body.Bytes(); recorder.Code != http.StatusOK || len(body) < 2 || body[0] != 0x1f || body[1] != 0x8b {
+		t.Errorf("unexpected CPU profile: %d %q", recorder.Code, recorder.Body)
+	}
+}
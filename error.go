@@ -1,6 +1,7 @@
 package uf
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 )
@@ -8,39 +9,143 @@ import (
 type HttpError struct {
 	Code    int    `json:"code"`
 	Message string `json:"message"`
+
+	// Additional headers sent with the error response. Eg. Retry-After. Held
+	// by pointer so that errors remain comparable (eg. by errors.Is).
+	header *http.Header
+
+	// The internal error that caused this one. Logged by the ErrorLogger
+	// but never sent to the client.
+	Cause error `json:"-"`
 }
 
 // get the error in string format
 func (e HttpError) Error() string {
-	return fmt.Sprintf("%d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+	s := fmt.Sprintf("%d %s: %s", e.Code, http.StatusText(e.Code), e.Message)
+
+	if e.Cause != nil {
+		s += ": " + e.Cause.Error()
+	}
+
+	return s
+}
+
+// Get the cause of the error for errors.Is and errors.As.
+func (e HttpError) Unwrap() error {
+	return e.Cause
+}
+
+// Get a copy of the error caused by cause. Eg.
+//
+//	return uf.NotFound("No such car").WithCause(e)
+func (e HttpError) WithCause(cause error) HttpError {
+	e.Cause = cause
+
+	return e
+}
+
+// Find the first HttpError in the chain of e. See errors.As.
+func AsHttpError(e error) (HttpError, bool) {
+	var he HttpError
+	ok := errors.As(e, &he)
+
+	return he, ok
+}
+
+// Create an error sending code and m to the client while logging cause. Eg.
+//
+//	if e := db.QueryRow(query, id).Scan(&car); e != nil {
+//		return uf.Wrap(e, http.StatusBadGateway, "The database is unavailable")
+//	}
+func Wrap(cause error, code int, m string) HttpError {
+	return HttpError{Code: code, Message: m, Cause: cause}
+}
+
+// 500 Internal Server Error caused by cause. The client receives a generic
+// message so that the details of cause are not disclosed.
+func WrapInternal(cause error) HttpError {
+	return Wrap(cause, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// Get the additional headers sent with the error response. Nil if none.
+func (e HttpError) Header() http.Header {
+	if e.header == nil {
+		return nil
+	}
+
+	return *e.header
+}
+
+// Get a copy of the error with the header key set to value.
+func (e HttpError) WithHeader(key, value string) HttpError {
+	h := e.Header().Clone()
+
+	if h == nil {
+		h = http.Header{}
+	}
+
+	h.Set(key, value)
+	e.header = &h
+
+	return e
 }
 
 // 400 Bad Request error
 func BadRequest(m string) HttpError {
-	return HttpError{http.StatusBadRequest, m}
+	return HttpError{Code: http.StatusBadRequest, Message: m}
 }
 
 // 401 Unauthorized error
 func Unauthorized(m string) HttpError {
-	return HttpError{http.StatusUnauthorized, m}
+	return HttpError{Code: http.StatusUnauthorized, Message: m}
 }
 
 // 403 Forbidden error
 func Forbidden(m string) HttpError {
-	return HttpError{http.StatusForbidden, m}
+	return HttpError{Code: http.StatusForbidden, Message: m}
 }
 
 // 404 Not Found error
 func NotFound(m string) HttpError {
-	return HttpError{http.StatusNotFound, m}
+	return HttpError{Code: http.StatusNotFound, Message: m}
 }
 
 // 405 Method Not Allowed error
 func MethodNotAllowed(m string) HttpError {
-	return HttpError{http.StatusMethodNotAllowed, m}
+	return HttpError{Code: http.StatusMethodNotAllowed, Message: m}
+}
+
+// 412 Precondition Failed error
+func PreconditionFailed(m string) HttpError {
+	return HttpError{Code: http.StatusPreconditionFailed, Message: m}
+}
+
+// 413 Request Entity Too Large error
+func RequestEntityTooLarge(m string) HttpError {
+	return HttpError{Code: http.StatusRequestEntityTooLarge, Message: m}
+}
+
+// 415 Unsupported Media Type error
+func UnsupportedMediaType(m string) HttpError {
+	return HttpError{Code: http.StatusUnsupportedMediaType, Message: m}
+}
+
+// 428 Precondition Required error
+func PreconditionRequired(m string) HttpError {
+	return HttpError{Code: http.StatusPreconditionRequired, Message: m}
+}
+
+// 429 Too Many Requests error
+func TooManyRequests(m string) HttpError {
+	return HttpError{Code: http.StatusTooManyRequests, Message: m}
 }
 
 // 500 Internal Server Error error
 func InternalServerError(m string) HttpError {
-	return HttpError{http.StatusInternalServerError, m}
+	return HttpError{Code: http.StatusInternalServerError, Message: m}
+}
+
+// 503 Service Unavailable error
+func ServiceUnavailable(m string) HttpError {
+	return HttpError{Code: http.StatusServiceUnavailable, Message: m}
 }
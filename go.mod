@@ -1,5 +1,5 @@
 module github.com/blacksfk/uf
 
-go 1.14
+go 1.18
 
 require github.com/julienschmidt/httprouter v1.3.0
@@ -1,50 +1,149 @@
 package uf
 
+import "time"
+
 // Stores the underlying endpoint, route-wide middleware, and server
 type Group struct {
 	endpoint   string
 	middleware []Middleware
+	ranked     []ranked
 	server     *Server
+	timeout    time.Duration
+	name       string
+	meta       map[string]interface{}
 }
 
 // Add middleware to be called for handlers following this method call for this group
 func (g *Group) Middleware(nextRoutes ...Middleware) *Group {
-	g.middleware = append(g.middleware, nextRoutes...)
+	g.middleware = chain(g.middleware, nextRoutes)
+
+	return g
+}
+
+// Add middleware with an explicit priority for handlers following this method
+// call for this group. See Server.AddGlobalMiddlewareAt for how priorities order the chain.
+func (g *Group) MiddlewareAt(priority int, m ...Middleware) *Group {
+	g.ranked = append(g.ranked[:len(g.ranked):len(g.ranked)], rank(priority, m)...)
 
 	return g
 }
 
+// Override Config.Timeout for handlers following this method call for this group.
+// A negative duration disables the timeout.
+func (g *Group) Timeout(d time.Duration) *Group {
+	g.timeout = d
+
+	return g
+}
+
+// Name the group's endpoint for Server.URL. Applies to routes bound
+// following this method call for this group.
+func (g *Group) Name(name string) *Group {
+	g.name = name
+
+	return g
+}
+
+// Attach metadata to routes bound following this method call for this group.
+func (g *Group) Meta(key string, value interface{}) *Group {
+	if g.meta == nil {
+		g.meta = make(map[string]interface{})
+	}
+
+	g.meta[key] = value
+
+	return g
+}
+
+// Get the route bound by the group for method in order to configure it
+// further. Returns nil if the method has not been bound.
+func (g *Group) Route(method string) *Route {
+	return g.server.route(method, g.endpoint)
+}
+
+// Apply the group's settings to a route bound by the group.
+func (g *Group) configure(rt *Route) {
+	if len(g.ranked) > 0 {
+		// the group's middleware precedes the route's own
+		rt.queue.rm = append(append([]ranked{}, g.ranked...), rt.queue.rm...)
+		rt.use(nil)
+	}
+
+	if g.timeout != 0 {
+		rt.Timeout(g.timeout)
+	}
+
+	if g.name != "" {
+		rt.Name(g.name)
+	}
+
+	for k, v := range g.meta {
+		rt.Meta(k, v)
+	}
+}
+
 // Bind this route to support GET requests, with methodOnly middleware only applied here
 func (g *Group) Get(h Handler, methodOnly ...Middleware) *Group {
-	g.server.Get(g.endpoint, h, append(g.middleware, methodOnly...)...)
+	g.configure(g.server.Get(g.endpoint, h, chain(g.middleware, methodOnly)...))
 
 	return g
 }
 
 // Bind this route to support POST requests, with methodOnly middleware only applied here
 func (g *Group) Post(h Handler, methodOnly ...Middleware) *Group {
-	g.server.Post(g.endpoint, h, append(g.middleware, methodOnly...)...)
+	g.configure(g.server.Post(g.endpoint, h, chain(g.middleware, methodOnly)...))
 
 	return g
 }
 
 // Bind this route to support PUT requests, with methodOnly middleware only applied here
 func (g *Group) Put(h Handler, methodOnly ...Middleware) *Group {
-	g.server.Put(g.endpoint, h, append(g.middleware, methodOnly...)...)
+	g.configure(g.server.Put(g.endpoint, h, chain(g.middleware, methodOnly)...))
 
 	return g
 }
 
 // Bind this route to support PATCH requests, with methodOnly middleware only applied here
 func (g *Group) Patch(h Handler, methodOnly ...Middleware) *Group {
-	g.server.Patch(g.endpoint, h, append(g.middleware, methodOnly...)...)
+	g.configure(g.server.Patch(g.endpoint, h, chain(g.middleware, methodOnly)...))
 
 	return g
 }
 
 // Bind this route to support DELETE requests, with methodOnly middleware only applied here
 func (g *Group) Delete(h Handler, methodOnly ...Middleware) *Group {
-	g.server.Delete(g.endpoint, h, append(g.middleware, methodOnly...)...)
+	g.configure(g.server.Delete(g.endpoint, h, chain(g.middleware, methodOnly)...))
+
+	return g
+}
+
+// Bind this route to support HEAD requests, with methodOnly middleware only applied here
+func (g *Group) Head(h Handler, methodOnly ...Middleware) *Group {
+	g.configure(g.server.Head(g.endpoint, h, chain(g.middleware, methodOnly)...))
+
+	return g
+}
+
+// Bind this route to support OPTIONS requests, with methodOnly middleware only applied here
+func (g *Group) Options(h Handler, methodOnly ...Middleware) *Group {
+	g.configure(g.server.Options(g.endpoint, h, chain(g.middleware, methodOnly)...))
+
+	return g
+}
+
+// Bind this route to support requests with method, with methodOnly middleware only applied here
+func (g *Group) Handle(method string, h Handler, methodOnly ...Middleware) *Group {
+	g.configure(g.server.Handle(method, g.endpoint, h, chain(g.middleware, methodOnly)...))
+
+	return g
+}
+
+// Bind this route to support requests with any standard method, with methodOnly
+// middleware only applied here
+func (g *Group) Any(h Handler, methodOnly ...Middleware) *Group {
+	for _, rt := range g.server.Any(g.endpoint, h, chain(g.middleware, methodOnly)...) {
+		g.configure(rt)
+	}
 
 	return g
 }
@@ -3,6 +3,7 @@ package uf
 import (
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestNewGroup(t *testing.T) {
@@ -27,13 +28,36 @@ func TestGroupMethods(t *testing.T) {
 	server := NewServer(&Config{})
 	group := server.NewGroup("/nothing", doNothing)
 
-	group.Get(handleNothing, doNothing).Post(handleNothing).Middleware(doNothing, doNothing).Put(handleNothing).Patch(handleNothing).Delete(handleNothing)
+	group.Get(handleNothing, doNothing).Post(handleNothing).Middleware(doNothing, doNothing).Put(handleNothing).Patch(handleNothing).Delete(handleNothing).
+		Head(handleNothing).Options(handleNothing).Handle("PROPFIND", handleNothing)
 
 	if len(group.middleware) != 3 {
 		t.Fatalf("middleware not appended: %+v", group)
 	}
 }
 
+func TestGroupTimeout(t *testing.T) {
+	server := NewServer(&Config{})
+	group := server.NewGroup("/nothing").Get(handleNothing).Timeout(time.Second).Post(handleNothing)
+
+	if group.timeout != time.Second {
+		t.Fatalf("timeout not set: %+v", group)
+	}
+
+	if h, _, _ := server.Lookup(http.MethodPost, "/nothing"); h == nil {
+		t.Fatal("route not bound")
+	}
+
+	if rt := server.route(http.MethodPost, "/nothing"); rt.queue.timeout != time.Second {
+		t.Errorf("expected the POST route's timeout to be 1s; got %v", rt.queue.timeout)
+	}
+
+	// bound before the call to Timeout
+	if rt := server.route(http.MethodGet, "/nothing"); rt.queue.timeout != 0 {
+		t.Errorf("expected the GET route's timeout to be unchanged; got %v", rt.queue.timeout)
+	}
+}
+
 func doNothing(r *http.Request) error {
 	return nil
 }
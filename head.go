@@ -0,0 +1,96 @@
+package uf
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Answers HEAD requests for a path bound to GET by running the GET route
+// and discarding its body. Replaced by an explicitly bound HEAD route.
+type headHandler struct {
+	get  *Queue
+	head http.Handler
+}
+
+func (hh *headHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if hh.head != nil {
+		hh.head.ServeHTTP(w, r)
+
+		return
+	}
+
+	hw := &headWriter{ResponseWriter: w}
+	hh.get.ServeHTTP(hw, r)
+	hw.finish()
+}
+
+// Discards the body of a response while counting it so that the
+// Content-Length of the equivalent GET response can be sent.
+type headWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+	sent bool
+}
+
+func (hw *headWriter) WriteHeader(code int) {
+	if hw.code == 0 {
+		hw.code = code
+	}
+}
+
+func (hw *headWriter) Write(b []byte) (int, error) {
+	if hw.code == 0 {
+		hw.code = http.StatusOK
+	}
+
+	hw.size += int64(len(b))
+
+	return len(b), nil
+}
+
+// Send the headers, adding Content-Length unless the handler set one or
+// the length is not yet known.
+func (hw *headWriter) send(complete bool) {
+	if hw.sent {
+		return
+	}
+
+	hw.sent = true
+	h := hw.Header()
+
+	if complete && hw.size > 0 && h.Get("Content-Length") == "" && h.Get("Transfer-Encoding") == "" {
+		h.Set("Content-Length", strconv.FormatInt(hw.size, 10))
+	}
+
+	hw.ResponseWriter.WriteHeader(hw.code)
+}
+
+// Flush implements http.Flusher. The headers of a streaming response are
+// sent without a Content-Length.
+func (hw *headWriter) Flush() {
+	if hw.code == 0 {
+		hw.code = http.StatusOK
+	}
+
+	hw.send(false)
+
+	if f, ok := hw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Send the headers once the handler has finished.
+func (hw *headWriter) finish() {
+	if hw.code == 0 {
+		// nothing was written; let the server send its default response
+		return
+	}
+
+	hw.send(true)
+}
+
+// Allows http.ResponseController to reach the underlying writer.
+func (hw *headWriter) Unwrap() http.ResponseWriter {
+	return hw.ResponseWriter
+}
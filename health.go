@@ -0,0 +1,311 @@
+package uf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checks the health of a component, returning an error if it is unhealthy.
+// The context is cancelled once the check's timeout has passed.
+type HealthCheck func(ctx context.Context) error
+
+// Health configuration.
+type HealthOptions struct {
+	// How long check results are reused before running the checks again.
+	// Defaults to 1 second. Negative to run the checks on every request.
+	CacheInterval time.Duration
+
+	// Default timeout of checks. Defaults to 2 seconds.
+	Timeout time.Duration
+
+	// How long Shutdown reports the server as draining before shutting it
+	// down, giving load balancers time to notice. Should exceed their
+	// readiness probe interval. Defaults to 5 seconds.
+	DrainPeriod time.Duration
+}
+
+// Check configuration.
+type CheckOptions struct {
+	// Maximum duration of the check. Defaults to HealthOptions.Timeout.
+	Timeout time.Duration
+
+	// Whether a failure fails the endpoint. Failures of other checks are
+	// reported without affecting the status code.
+	Critical bool
+
+	// Run the check for liveness (/healthz) as well as readiness (/readyz).
+	// Only checks whose failure requires restarting the process should be.
+	Liveness bool
+}
+
+// Registry of named health checks reported by the liveness (/healthz) and
+// readiness (/readyz) endpoints. Every server has one (see Server.Health)
+// configured by Config.Health:
+//
+//	server.Health().Register("db", func(ctx context.Context) error {
+//		return db.PingContext(ctx)
+//	}, &uf.CheckOptions{Critical: true})
+//
+//	server.MountHealth("")
+//
+// Shut the http.Server down with Shutdown rather than http.Server.Shutdown so
+// that readiness fails, and load balancers stop sending requests, while the
+// server is still listening:
+//
+//	<-sigterm
+//	server.Health().Shutdown(ctx, httpServer)
+//
+// Functions registered with http.Server.RegisterOnShutdown run after the
+// listeners have been closed, too late to report readiness.
+type Health struct {
+	opts     HealthOptions
+	mu       sync.RWMutex
+	checks   map[string]*check
+	draining int32
+}
+
+type check struct {
+	name string
+	fn   HealthCheck
+	opts CheckOptions
+
+	// the last result
+	mu      sync.Mutex
+	result  CheckResult
+	expires time.Time
+}
+
+// The outcome of a check.
+type CheckResult struct {
+	// "ok" or "failing".
+	Status string `json:"status"`
+
+	Critical bool   `json:"critical"`
+	Error    string `json:"error,omitempty"`
+
+	// Time taken by the check.
+	Duration string `json:"duration"`
+
+	// When the check was run; earlier than the request if cached.
+	Time time.Time `json:"time"`
+}
+
+// The body of the health endpoints.
+type HealthReport struct {
+	// "ok", "degraded" if a check that is not critical failed, "failing" if a
+	// critical check failed, or "draining" if readiness fails due to shutdown.
+	Status string `json:"status"`
+
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// Create an empty registry. opts may be nil for the defaults.
+func NewHealth(opts *HealthOptions) *Health {
+	h := &Health{checks: make(map[string]*check)}
+
+	if opts != nil {
+		h.opts = *opts
+	}
+
+	if h.opts.CacheInterval == 0 {
+		h.opts.CacheInterval = time.Second
+	}
+
+	if h.opts.Timeout <= 0 {
+		h.opts.Timeout = 2 * time.Second
+	}
+
+	if h.opts.DrainPeriod <= 0 {
+		h.opts.DrainPeriod = 5 * time.Second
+	}
+
+	return h
+}
+
+// Register fn as the check named name, replacing any previous check of the
+// same name. opts may be nil for a readiness check that is not critical.
+func (h *Health) Register(name string, fn HealthCheck, opts *CheckOptions) {
+	c := &check{name: name, fn: fn}
+
+	if opts != nil {
+		c.opts = *opts
+	}
+
+	if c.opts.Timeout <= 0 {
+		c.opts.Timeout = h.opts.Timeout
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.checks[name] = c
+}
+
+// Remove the check named name.
+func (h *Health) Unregister(name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.checks, name)
+}
+
+// Fail readiness from now on. Liveness is unaffected.
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Drain, wait for HealthOptions.DrainPeriod while still serving requests,
+// then gracefully shut srv down with http.Server.Shutdown. If ctx is done
+// before the period has passed srv is closed immediately with
+// http.Server.Close and the context's error returned.
+func (h *Health) Shutdown(ctx context.Context, srv *http.Server) error {
+	h.Drain()
+
+	timer := time.NewTimer(h.opts.DrainPeriod)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		if e := srv.Close(); e != nil {
+			return fmt.Errorf("%w; close: %v", ctx.Err(), e)
+		}
+
+		return ctx.Err()
+	}
+
+	return srv.Shutdown(ctx)
+}
+
+// Whether Drain has been called.
+func (h *Health) Draining() bool {
+	return atomic.LoadInt32(&h.draining) == 1
+}
+
+// Handler reporting liveness: the checks registered with CheckOptions.Liveness.
+// Responds 503 Service Unavailable if a critical check failed.
+func (h *Health) Liveness(w http.ResponseWriter, r *http.Request) error {
+	return h.send(w, h.Report(true))
+}
+
+// Handler reporting readiness: every check. Responds 503 Service Unavailable
+// if a critical check failed or the server is draining.
+func (h *Health) Readiness(w http.ResponseWriter, r *http.Request) error {
+	if h.Draining() {
+		return h.send(w, HealthReport{Status: "draining"})
+	}
+
+	return h.send(w, h.Report(false))
+}
+
+func (h *Health) send(w http.ResponseWriter, report HealthReport) error {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+
+	if report.Status == "failing" || report.Status == "draining" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	return SendJSON(w, report)
+}
+
+// Run the checks concurrently, or reuse their cached results, and report
+// them. Only the liveness checks are run if liveness is true.
+func (h *Health) Report(liveness bool) HealthReport {
+	h.mu.RLock()
+	checks := make([]*check, 0, len(h.checks))
+
+	for _, c := range h.checks {
+		if c.opts.Liveness || !liveness {
+			checks = append(checks, c)
+		}
+	}
+
+	h.mu.RUnlock()
+
+	// run in a fixed order so that results are reproducible
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+
+	results := make([]CheckResult, len(checks))
+	var wg sync.WaitGroup
+
+	for i, c := range checks {
+		wg.Add(1)
+
+		go func(i int, c *check) {
+			defer wg.Done()
+
+			results[i] = c.run(h.opts.CacheInterval)
+		}(i, c)
+	}
+
+	wg.Wait()
+
+	report := HealthReport{Status: "ok", Checks: make(map[string]CheckResult, len(checks))}
+
+	for i, c := range checks {
+		report.Checks[c.name] = results[i]
+
+		if results[i].Status == "ok" {
+			continue
+		}
+
+		if c.opts.Critical {
+			report.Status = "failing"
+		} else if report.Status == "ok" {
+			report.Status = "degraded"
+		}
+	}
+
+	return report
+}
+
+// Get the result of the check, running it if the cached result has expired.
+// Concurrent requests wait for the same run.
+func (c *check) run(interval time.Duration) CheckResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Before(c.expires) {
+		return c.result
+	}
+
+	// not the context of a request as the result is shared
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- c.fn(ctx)
+	}()
+
+	var e error
+
+	// checks ignoring their context are abandoned once it is done
+	select {
+	case e = <-done:
+	case <-ctx.Done():
+		e = errors.New("timed out after " + c.opts.Timeout.String())
+	}
+
+	c.result = CheckResult{Status: "ok", Critical: c.opts.Critical, Time: now}
+	c.result.Duration = time.Since(now).String()
+
+	if e != nil {
+		c.result.Status = "failing"
+		c.result.Error = e.Error()
+	}
+
+	c.expires = now.Add(interval)
+
+	return c.result
+}
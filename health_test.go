@@ -0,0 +1,215 @@
+package uf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func health(t *testing.T, s *Server, path string) (int, HealthReport) {
+	t.Helper()
+
+	var report HealthReport
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+
+	if e := json.Unmarshal(recorder.Body.Bytes(), &report); e != nil {
+		t.Fatal(e)
+	}
+
+	return recorder.Code, report
+}
+
+func TestHealth(t *testing.T) {
+	s := NewServer(&Config{Health: &HealthOptions{CacheInterval: time.Hour}})
+	var cacheFailing, dbFailing int32
+	var runs int32
+
+	s.Health().Register("db", func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+
+		if atomic.LoadInt32(&dbFailing) == 1 {
+			return errors.New("connection refused")
+		}
+
+		return nil
+	}, &CheckOptions{Critical: true})
+
+	s.Health().Register("cache", func(ctx context.Context) error {
+		if atomic.LoadInt32(&cacheFailing) == 1 {
+			return errors.New("evicted")
+		}
+
+		return nil
+	}, nil)
+
+	s.Health().Register("deadlock", func(ctx context.Context) error {
+		return nil
+	}, &CheckOptions{Critical: true, Liveness: true})
+
+	s.MountHealth("/")
+
+	code, report := health(t, s, "/readyz")
+
+	if code != http.StatusOK || report.Status != "ok" || len(report.Checks) != 3 {
+		t.Errorf("unexpected readiness: %d %+v", code, report)
+	}
+
+	if db := report.Checks["db"]; db.Status != "ok" || !db.Critical || db.Duration == "" || db.Time.IsZero() {
+		t.Errorf("unexpected result: %+v", db)
+	}
+
+	code, report = health(t, s, "/healthz")
+
+	if code != http.StatusOK || len(report.Checks) != 1 || report.Checks["deadlock"].Status != "ok" {
+		t.Errorf("liveness must only run liveness checks: %d %+v", code, report)
+	}
+
+	// cached
+	atomic.StoreInt32(&dbFailing, 1)
+	health(t, s, "/readyz")
+
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Errorf("expected the cached result to be used; ran %d times", n)
+	}
+
+	s = NewServer(&Config{Health: &HealthOptions{CacheInterval: -1}})
+	s.Health().Register("cache", func(ctx context.Context) error {
+		if atomic.LoadInt32(&cacheFailing) == 1 {
+			return errors.New("evicted")
+		}
+
+		return nil
+	}, nil)
+
+	s.MountHealth("")
+	atomic.StoreInt32(&cacheFailing, 1)
+	code, report = health(t, s, "/readyz")
+
+	if code != http.StatusOK || report.Status != "degraded" || report.Checks["cache"].Error != "evicted" {
+		t.Errorf("failures of checks that are not critical must not fail readiness: %d %+v", code, report)
+	}
+
+	s.Health().Register("db", func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}, &CheckOptions{Critical: true})
+
+	code, report = health(t, s, "/readyz")
+
+	if code != http.StatusServiceUnavailable || report.Status != "failing" {
+		t.Errorf("unexpected readiness: %d %+v", code, report)
+	}
+
+	s.Health().Unregister("db")
+
+	if _, report = health(t, s, "/readyz"); len(report.Checks) != 1 {
+		t.Errorf("unexpected checks: %+v", report.Checks)
+	}
+}
+
+func TestHealthTimeout(t *testing.T) {
+	h := NewHealth(nil)
+	block := make(chan struct{})
+	defer close(block)
+
+	h.Register("slow", func(ctx context.Context) error {
+		<-ctx.Done()
+
+		return ctx.Err()
+	}, &CheckOptions{Timeout: 10 * time.Millisecond, Critical: true})
+
+	// ignores its context
+	h.Register("stuck", func(ctx context.Context) error {
+		<-block
+
+		return nil
+	}, &CheckOptions{Timeout: 10 * time.Millisecond})
+
+	report := h.Report(false)
+
+	if report.Status != "failing" || report.Checks["slow"].Status != "failing" {
+		t.Errorf("unexpected report: %+v", report)
+	}
+
+	if stuck := report.Checks["stuck"]; stuck.Error != "timed out after 10ms" {
+		t.Errorf("unexpected result: %+v", stuck)
+	}
+}
+
+func TestHealthDrain(t *testing.T) {
+	s := NewServer(&Config{Health: &HealthOptions{DrainPeriod: 200 * time.Millisecond}})
+	s.MountHealth("/internal")
+
+	httpServer := httptest.NewServer(s)
+	defer httpServer.Close()
+
+	if code, _ := health(t, s, "/internal/readyz"); code != http.StatusOK {
+		t.Errorf("expected ready; got %d", code)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	shutdown := make(chan error, 1)
+
+	go func() {
+		shutdown <- s.Health().Shutdown(ctx, httpServer.Config)
+	}()
+
+	for i := 0; i < 100 && !s.Health().Draining(); i++ {
+		time.Sleep(time.Millisecond)
+	}
+
+	// still listening while draining so load balancers see readiness fail
+	res, e := http.Get(httpServer.URL + "/internal/readyz")
+
+	if e != nil {
+		t.Fatalf("the server must listen while draining: %v", e)
+	}
+
+	var report HealthReport
+	json.NewDecoder(res.Body).Decode(&report)
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusServiceUnavailable || report.Status != "draining" {
+		t.Errorf("readiness must fail once draining: %d %+v", res.StatusCode, report)
+	}
+
+	if code, _ := health(t, s, "/internal/healthz"); code != http.StatusOK {
+		t.Errorf("liveness must not fail when draining; got %d", code)
+	}
+
+	select {
+	case e := <-shutdown:
+		t.Fatalf("shut down before the drain period: %v", e)
+	default:
+	}
+
+	if e := <-shutdown; e != nil {
+		t.Fatal(e)
+	}
+
+	if _, e := http.Get(httpServer.URL + "/internal/readyz"); e == nil {
+		t.Error("the server must not listen once shut down")
+	}
+
+	// the context ends the drain period early, closing the server
+	early := httptest.NewServer(s)
+	defer early.Close()
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+
+	if e := NewHealth(nil).Shutdown(ctx, early.Config); !errors.Is(e, context.Canceled) {
+		t.Errorf("expected: %v, actual: %v", context.Canceled, e)
+	}
+
+	if _, e := http.Get(early.URL + "/internal/readyz"); e == nil {
+		t.Error("the server must not listen once the context is done")
+	}
+}
@@ -0,0 +1,88 @@
+package uf
+
+import (
+	"net/http"
+	"time"
+)
+
+// Describes a response once it has been written. Supplied to Config.OnResponse.
+type ResponseInfo struct {
+	// Status code sent. 200 if the handler did not write a response.
+	Status int
+
+	// Number of body bytes written, after any compression.
+	Size int64
+
+	// Time taken to serve the request.
+	Duration time.Duration
+}
+
+// Functions called by a Queue at points in the lifecycle of a request. Copied from Config.
+type hooks struct {
+	onRequest  func(*http.Request)
+	onHandler  func(*http.Request)
+	onResponse func(*http.Request, ResponseInfo)
+	onError    func(*http.Request, error)
+	onPanic    func(*http.Request, interface{}, []byte)
+}
+
+func newHooks(config *Config) hooks {
+	return hooks{
+		onRequest:  config.OnRequest,
+		onHandler:  config.OnHandler,
+		onResponse: config.OnResponse,
+		onError:    config.OnError,
+		onPanic:    config.OnPanic,
+	}
+}
+
+// Records the status code and size of a response.
+type recordingWriter struct {
+	http.ResponseWriter
+	code int
+	size int64
+}
+
+func (rw *recordingWriter) WriteHeader(code int) {
+	if rw.code == 0 {
+		rw.code = code
+	}
+
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *recordingWriter) Write(b []byte) (int, error) {
+	if rw.code == 0 {
+		rw.code = http.StatusOK
+	}
+
+	n, e := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+
+	return n, e
+}
+
+// Flush implements http.Flusher.
+func (rw *recordingWriter) Flush() {
+	if rw.code == 0 {
+		rw.code = http.StatusOK
+	}
+
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Allows http.ResponseController to reach the underlying writer.
+func (rw *recordingWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// The status code sent, assuming the server sends 200 if nothing was written.
+func (rw *recordingWriter) status() int {
+	if rw.code == 0 {
+		return http.StatusOK
+	}
+
+	return rw.code
+}
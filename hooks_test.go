@@ -0,0 +1,95 @@
+package uf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHooks(t *testing.T) {
+	var events []string
+	var response ResponseInfo
+
+	s := NewServer(&Config{
+		OnRequest: func(r *http.Request) {
+			events = append(events, "request "+RoutePattern(r))
+		},
+		OnHandler: func(r *http.Request) {
+			events = append(events, "handler")
+		},
+		OnResponse: func(r *http.Request, info ResponseInfo) {
+			events = append(events, "response")
+			response = info
+		},
+		OnError: func(r *http.Request, e error) {
+			events = append(events, "error "+e.Error())
+		},
+	}, func(r *http.Request) error {
+		events = append(events, "middleware")
+
+		if r.URL.Query().Get("deny") != "" {
+			return Forbidden("denied")
+		}
+
+		return nil
+	})
+
+	s.Get("/car/:id", func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, "GT1")
+	})
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/car/1", nil))
+
+	if actual := strings.Join(events, ","); actual != "request /car/:id,middleware,handler,response" {
+		t.Errorf("unexpected events: %s", actual)
+	}
+
+	if response.Status != http.StatusOK || response.Size != int64(len("\"GT1\"\n")) || response.Duration <= 0 {
+		t.Errorf("unexpected response info: %+v", response)
+	}
+
+	events = nil
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/car/1?deny=1", nil))
+
+	if actual := strings.Join(events, ","); actual != "request /car/:id,middleware,error 403 Forbidden: denied,response" {
+		t.Errorf("unexpected events: %s", actual)
+	}
+
+	if response.Status != http.StatusForbidden {
+		t.Errorf("unexpected status: %d", response.Status)
+	}
+}
+
+func TestOnPanic(t *testing.T) {
+	var recovered interface{}
+	var stack []byte
+	var deferred error
+
+	s := NewServer(&Config{
+		OnPanic: func(r *http.Request, p interface{}, s []byte) {
+			recovered, stack = p, s
+		},
+	})
+
+	s.Get("/", func(w http.ResponseWriter, r *http.Request) error {
+		Defer(r, func(e error) { deferred = e })
+
+		panic("engine failure")
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusInternalServerError || strings.Contains(recorder.Body.String(), "engine") {
+		t.Errorf("unexpected response: %d %s", recorder.Code, recorder.Body)
+	}
+
+	if recovered != "engine failure" || len(stack) == 0 {
+		t.Errorf("panic was not supplied: %v", recovered)
+	}
+
+	if deferred != ErrPanicked {
+		t.Errorf("deferred functions received: %v", deferred)
+	}
+}
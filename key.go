@@ -0,0 +1,151 @@
+package uf
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// A typed key for request-scoped values. Middleware sets values that later
+// middleware and the handler get without type assertions or copying the request:
+//
+//	var userKey = uf.NewKey[*User]("user")
+//
+//	func authenticate(r *http.Request) error {
+//		user, e := database.FindUser(r.Header.Get("Authorization"))
+//
+//		if e != nil {
+//			return uf.Unauthorized("Invalid login")
+//		}
+//
+//		userKey.Set(r, user)
+//
+//		return nil
+//	}
+//
+//	func handler(w http.ResponseWriter, r *http.Request) error {
+//		user := userKey.MustGet(r)
+//
+//		// ...
+//	}
+type Key[T any] struct {
+	name string
+}
+
+// Create a key. The name is only used in messages.
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+// The name of the key.
+func (k *Key[T]) String() string {
+	return k.name
+}
+
+// Set the value of the key for the request r. While r is being served by a
+// Queue the value is visible to the remaining middleware and the handler;
+// otherwise (eg. in tests) r is replaced with a copy carrying the value.
+func (k *Key[T]) Set(r *http.Request, v T) {
+	if s := stateFrom(r); s != nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.values == nil {
+			s.values = make(map[interface{}]interface{})
+		}
+
+		s.values[k] = v
+
+		return
+	}
+
+	*r = *r.WithContext(context.WithValue(r.Context(), k, v))
+}
+
+// Get the value of the key for the request r.
+func (k *Key[T]) Get(r *http.Request) (T, bool) {
+	return k.Value(r.Context())
+}
+
+// Get the value of the key for the request r. Panics if the value was not
+// set, indicating the middleware setting it was not bound to the route.
+func (k *Key[T]) MustGet(r *http.Request) T {
+	v, ok := k.Get(r)
+
+	if !ok {
+		panic(fmt.Sprintf("uf: value for key %q not set", k.name))
+	}
+
+	return v
+}
+
+// Get the value of the key from the context of a request, or a context
+// derived from it. Eg. in a function only supplied r.Context().
+func (k *Key[T]) Value(ctx context.Context) (T, bool) {
+	if s, _ := ctx.Value(stateKey{}).(*state); s != nil {
+		s.mu.Lock()
+		v, ok := s.values[k]
+		s.mu.Unlock()
+
+		if ok {
+			return v.(T), true
+		}
+	}
+
+	v, ok := ctx.Value(k).(T)
+
+	return v, ok
+}
+
+// Functions implementing this type are middleware that may return a
+// replacement for the request. Eg. one with a derived context. A nil request
+// keeps the current one.
+type RequestMiddleware func(*http.Request) (*http.Request, error)
+
+// Adapt m to Middleware. The request returned by m is passed to the
+// remaining middleware and the handler.
+//
+//	server := uf.NewServer(config, uf.Replace(func(r *http.Request) (*http.Request, error) {
+//		ctx, span := tracer.Start(r.Context(), r.URL.Path)
+//
+//		// ...
+//
+//		return r.WithContext(ctx), nil
+//	}))
+func Replace(m RequestMiddleware) Middleware {
+	return func(r *http.Request) error {
+		next, e := m(r)
+
+		if e != nil || next == nil || next == r {
+			return e
+		}
+
+		if s := stateFrom(r); s != nil {
+			s.next = next
+		} else {
+			*r = *next
+		}
+
+		return nil
+	}
+}
+
+// Get the request to pass to the next middleware after r, taking any
+// replacement returned by a RequestMiddleware.
+func advance(r *http.Request) *http.Request {
+	s := stateFrom(r)
+
+	if s == nil || s.next == nil {
+		return r
+	}
+
+	next := s.next
+	s.next = nil
+
+	if stateFrom(next) != s {
+		// the replacement was not derived from r
+		next = withState(next, s)
+	}
+
+	return next
+}
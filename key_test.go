@@ -0,0 +1,101 @@
+package uf
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+var carKey = NewKey[GT1]("car")
+
+type traceKey struct{}
+
+func TestKey(t *testing.T) {
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		car, ok := carKey.Get(r)
+
+		if !ok || car.Model != "V12 LM" {
+			t.Errorf("unexpected value: %+v %v", car, ok)
+		}
+
+		return nil
+	}, []Middleware{func(r *http.Request) error {
+		carKey.Set(r, GT1{"BMW", "V12 LM", 1998})
+
+		return nil
+	}}, &Config{})
+
+	q.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// outside of a queue
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if _, ok := carKey.Get(r); ok {
+		t.Error("value should not be set")
+	}
+
+	carKey.Set(r, GT1{"Nissan", "R390 LM", 1997})
+
+	if car := carKey.MustGet(r); car.Manufacturer != "Nissan" {
+		t.Errorf("unexpected value: %+v", car)
+	}
+}
+
+func TestKeyMustGet(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic for a missing value")
+		}
+	}()
+
+	carKey.MustGet(httptest.NewRequest(http.MethodGet, "/", nil))
+}
+
+func TestReplace(t *testing.T) {
+	replace := Replace(func(r *http.Request) (*http.Request, error) {
+		return r.WithContext(context.WithValue(r.Context(), traceKey{}, "abc")), nil
+	})
+
+	check := func(r *http.Request) error {
+		if r.Context().Value(traceKey{}) != "abc" {
+			t.Error("replacement request was not passed on")
+		}
+
+		return nil
+	}
+
+	tests := [][]Middleware{
+		{replace, check},
+		{When(PathIs("/"), replace), check},
+		{When(PathIs("/"), replace, check), check},
+	}
+
+	for _, m := range tests {
+		q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+			return check(r)
+		}, m, &Config{})
+
+		q.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	}
+
+	// a request not derived from the original keeps the queue's state
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		if ResponseHeader(r).Get("X-Car") != "GT1" {
+			t.Error("state was not attached to the replacement")
+		}
+
+		return nil
+	}, []Middleware{
+		Replace(func(r *http.Request) (*http.Request, error) {
+			return httptest.NewRequest(http.MethodGet, "/", nil), nil
+		}),
+		func(r *http.Request) error {
+			ResponseHeader(r).Set("X-Car", "GT1")
+
+			return nil
+		},
+	}, &Config{})
+
+	q.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
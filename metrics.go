@@ -0,0 +1,302 @@
+package uf
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics configuration.
+type MetricsOptions struct {
+	// Prefix of every metric name followed by an underscore. Eg. "shop"
+	// produces shop_http_requests_total.
+	Namespace string
+
+	// Upper bounds in seconds of the request duration histogram buckets.
+	// Defaults to those of the Prometheus client libraries (5ms to 10s).
+	DurationBuckets []float64
+
+	// Upper bounds in bytes of the response size histogram buckets.
+	// Defaults to powers of ten from 100B to 10MB.
+	SizeBuckets []float64
+}
+
+// Request metrics labelled by method, route pattern and status class (Eg.
+// 2xx), recorded by every Queue of a server configured with them (see
+// Config.Metrics) and exposed in the Prometheus text format:
+//
+//	metrics := uf.NewMetrics(nil)
+//	server := uf.NewServer(&uf.Config{Metrics: metrics})
+//
+//	server.Get("/metrics", metrics.Handle)
+//
+// Labelling by route pattern rather than path keeps the number of series
+// bounded. Requests not matching a route are labelled with an empty route.
+type Metrics struct {
+	opts     MetricsOptions
+	mu       sync.Mutex
+	series   map[seriesKey]*series
+	inFlight map[seriesKey]int64
+}
+
+type seriesKey struct {
+	method, route, status string
+}
+
+type series struct {
+	count    uint64
+	duration histogram
+	size     histogram
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, not cumulative
+	sum    float64
+}
+
+func (h *histogram) observe(bounds []float64, v float64) {
+	if h.counts == nil {
+		// the last count is for the +Inf bucket
+		h.counts = make([]uint64, len(bounds)+1)
+	}
+
+	h.counts[sort.SearchFloat64s(bounds, v)]++
+	h.sum += v
+}
+
+// Create metrics. opts may be nil for the defaults.
+func NewMetrics(opts *MetricsOptions) *Metrics {
+	m := &Metrics{series: make(map[seriesKey]*series), inFlight: make(map[seriesKey]int64)}
+
+	if opts != nil {
+		m.opts = *opts
+	}
+
+	if len(m.opts.DurationBuckets) == 0 {
+		m.opts.DurationBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+	}
+
+	if len(m.opts.SizeBuckets) == 0 {
+		m.opts.SizeBuckets = []float64{100, 1e3, 1e4, 1e5, 1e6, 1e7}
+	}
+
+	m.opts.DurationBuckets = sortedCopy(m.opts.DurationBuckets)
+	m.opts.SizeBuckets = sortedCopy(m.opts.SizeBuckets)
+
+	if m.opts.Namespace != "" {
+		m.opts.Namespace += "_"
+	}
+
+	return m
+}
+
+func sortedCopy(f []float64) []float64 {
+	c := append([]float64{}, f...)
+	sort.Float64s(c)
+
+	return c
+}
+
+// Methods reported as themselves. Others are reported as OTHER so that
+// clients cannot create series at will.
+var metricMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPost:    true,
+	http.MethodPut:     true,
+	http.MethodPatch:   true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+func metricMethod(r *http.Request, route string) string {
+	if metricMethods[r.Method] || route != "" {
+		// methods bound to a route are bounded by the routes
+		return r.Method
+	}
+
+	return "OTHER"
+}
+
+// Record the start of a request for route and return the function recording its end.
+func (m *Metrics) start(r *http.Request, route string) func(status int, size int64, d time.Duration) {
+	method := metricMethod(r, route)
+	key := seriesKey{method: method, route: route}
+
+	m.mu.Lock()
+	m.inFlight[key]++
+	m.mu.Unlock()
+
+	return func(status int, size int64, d time.Duration) {
+		sk := seriesKey{method, route, strconv.Itoa(status/100) + "xx"}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.inFlight[key]--
+		s, ok := m.series[sk]
+
+		if !ok {
+			s = &series{}
+			m.series[sk] = s
+		}
+
+		s.count++
+		s.duration.observe(m.opts.DurationBuckets, d.Seconds())
+		s.size.observe(m.opts.SizeBuckets, float64(size))
+	}
+}
+
+// Handler sending the metrics in the Prometheus text exposition format.
+func (m *Metrics) Handle(w http.ResponseWriter, r *http.Request) error {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, e := m.WriteTo(w)
+
+	return e
+}
+
+// Write the metrics to w in the Prometheus text exposition format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	keys := make([]seriesKey, 0, len(m.series))
+	snapshot := make(map[seriesKey]series, len(m.series))
+
+	for k, s := range m.series {
+		keys = append(keys, k)
+		snapshot[k] = series{
+			count:    s.count,
+			duration: histogram{append([]uint64{}, s.duration.counts...), s.duration.sum},
+			size:     histogram{append([]uint64{}, s.size.counts...), s.size.sum},
+		}
+	}
+
+	inFlightKeys := make([]seriesKey, 0, len(m.inFlight))
+	inFlight := make(map[seriesKey]int64, len(m.inFlight))
+
+	for k, n := range m.inFlight {
+		inFlightKeys = append(inFlightKeys, k)
+		inFlight[k] = n
+	}
+
+	m.mu.Unlock()
+
+	sortKeys(keys)
+	sortKeys(inFlightKeys)
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	ns := m.opts.Namespace
+
+	writeHeader(cw, ns+"http_requests_total", "counter", "Requests served.")
+
+	for _, k := range keys {
+		cw.printf("%shttp_requests_total{%s} %d\n", ns, k.labels(), snapshot[k].count)
+	}
+
+	writeHeader(cw, ns+"http_request_duration_seconds", "histogram", "Time taken to serve requests.")
+
+	for _, k := range keys {
+		h := snapshot[k].duration
+		writeHistogram(cw, ns+"http_request_duration_seconds", k.labels(), m.opts.DurationBuckets, h)
+	}
+
+	writeHeader(cw, ns+"http_response_size_bytes", "histogram", "Size of response bodies.")
+
+	for _, k := range keys {
+		h := snapshot[k].size
+		writeHistogram(cw, ns+"http_response_size_bytes", k.labels(), m.opts.SizeBuckets, h)
+	}
+
+	writeHeader(cw, ns+"http_requests_in_flight", "gauge", "Requests currently being served.")
+
+	for _, k := range inFlightKeys {
+		cw.printf("%shttp_requests_in_flight{method=\"%s\",route=\"%s\"} %d\n",
+			ns, escapeLabel(k.method), escapeLabel(k.route), inFlight[k])
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+
+	return cw.n, cw.err
+}
+
+func sortKeys(keys []seriesKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+
+		if a.route != b.route {
+			return a.route < b.route
+		}
+
+		if a.method != b.method {
+			return a.method < b.method
+		}
+
+		return a.status < b.status
+	})
+}
+
+func (k seriesKey) labels() string {
+	return `method="` + escapeLabel(k.method) + `",route="` + escapeLabel(k.route) +
+		`",status="` + escapeLabel(k.status) + `"`
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+func writeHeader(cw *countingWriter, name, kind, help string) {
+	cw.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(cw *countingWriter, name, labels string, bounds []float64, h histogram) {
+	var cumulative uint64
+
+	for i, n := range h.counts {
+		bound := math.Inf(1)
+
+		if i < len(bounds) {
+			bound = bounds[i]
+		}
+
+		cumulative += n
+		cw.printf("%s_bucket{%s,le=\"%s\"} %d\n", name, labels, formatFloat(bound), cumulative)
+	}
+
+	cw.printf("%s_sum{%s} %s\n", name, labels, formatFloat(h.sum))
+	cw.printf("%s_count{%s} %d\n", name, labels, cumulative)
+}
+
+// Counts the bytes written and keeps the first error.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (cw *countingWriter) printf(format string, args ...interface{}) {
+	if cw.err != nil {
+		return
+	}
+
+	n, e := fmt.Fprintf(cw.w, format, args...)
+	cw.n += int64(n)
+	cw.err = e
+}
@@ -0,0 +1,77 @@
+package uf
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	metrics := NewMetrics(&MetricsOptions{DurationBuckets: []float64{1, 0.1}, SizeBuckets: []float64{10}})
+	s := NewServer(&Config{Metrics: metrics})
+
+	s.Get("/car/:id", func(w http.ResponseWriter, r *http.Request) error {
+		if GetParam(r, "id") == "0" {
+			return NotFound("no such car")
+		}
+
+		return SendJSON(w, "GT1")
+	})
+
+	s.Get("/metrics", metrics.Handle)
+
+	for _, path := range []string{"/car/1", "/car/2", "/car/0", "/track/\"spa\""} {
+		s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := recorder.Body.String()
+
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("unexpected Content-Type: %s", recorder.Header().Get("Content-Type"))
+	}
+
+	expected := []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{method="GET",route="/car/:id",status="2xx"} 2`,
+		`http_requests_total{method="GET",route="/car/:id",status="4xx"} 1`,
+		`http_requests_total{method="GET",route="",status="4xx"} 1`,
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{method="GET",route="/car/:id",status="2xx",le="0.1"} 2`,
+		`http_request_duration_seconds_bucket{method="GET",route="/car/:id",status="2xx",le="+Inf"} 2`,
+		`http_request_duration_seconds_count{method="GET",route="/car/:id",status="2xx"} 2`,
+		`http_response_size_bytes_bucket{method="GET",route="/car/:id",status="2xx",le="10"} 2`,
+		`http_response_size_bytes_sum{method="GET",route="/car/:id",status="2xx"} 12`,
+		// the request for the metrics is in flight
+		`http_requests_in_flight{method="GET",route="/metrics"} 1`,
+		`http_requests_in_flight{method="GET",route="/car/:id"} 0`,
+	}
+
+	for _, line := range expected {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %s in:\n%s", line, body)
+		}
+	}
+
+	if strings.Contains(body, "spa") {
+		t.Error("unmatched paths must not be used as labels")
+	}
+}
+
+func TestMetricsMethod(t *testing.T) {
+	r := httptest.NewRequest("BREW", "/", nil)
+
+	if method := metricMethod(r, ""); method != "OTHER" {
+		t.Errorf("unexpected method label for an unmatched request: %s", method)
+	}
+
+	if method := metricMethod(r, "/coffee"); method != "BREW" {
+		t.Errorf("unexpected method label for a bound method: %s", method)
+	}
+
+	if escapeLabel("a\"b\\c\n") != `a\"b\\c\n` {
+		t.Errorf("unexpected escaping: %s", escapeLabel("a\"b\\c\n"))
+	}
+}
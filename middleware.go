@@ -0,0 +1,153 @@
+package uf
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Functions implementing this type decide whether middleware applies to a request.
+type Predicate func(*http.Request) bool
+
+// Call m in order only for requests matching p. Eg. only for writes:
+//
+//	uf.When(uf.MethodIs(http.MethodPost, http.MethodPut), requireCSRFToken)
+func When(p Predicate, m ...Middleware) Middleware {
+	return func(r *http.Request) error {
+		if !p(r) {
+			return nil
+		}
+
+		original := r
+
+		for _, fn := range m {
+			if e := fn(r); e != nil {
+				return e
+			}
+
+			r = advance(r)
+		}
+
+		if s := stateFrom(r); s != nil && r != original {
+			// pass the replacement on to the Queue
+			s.next = r
+		}
+
+		return nil
+	}
+}
+
+// Call m in order only for requests not matching p.
+func Unless(p Predicate, m ...Middleware) Middleware {
+	return When(func(r *http.Request) bool { return !p(r) }, m...)
+}
+
+// Call m for every request except those for the listed routes. Each route
+// is a path, a route pattern or either prefixed by a method. Eg:
+//
+//	server := uf.NewServer(config, uf.Except(authenticate, "/health", "POST /login", "/book/:id"))
+func Except(m Middleware, routes ...string) Middleware {
+	return Unless(RouteIs(routes...), m)
+}
+
+// Match requests whose path is one of paths.
+func PathIs(paths ...string) Predicate {
+	return func(r *http.Request) bool {
+		for _, path := range paths {
+			if r.URL.Path == path {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Match requests whose path begins with one of prefixes.
+func PathPrefix(prefixes ...string) Predicate {
+	return func(r *http.Request) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(r.URL.Path, prefix) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Match requests with one of methods.
+func MethodIs(methods ...string) Predicate {
+	return func(r *http.Request) bool {
+		for _, method := range methods {
+			if r.Method == method {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Match requests for one of routes. Each route is a path, a route pattern
+// (Eg. /book/:id) or either prefixed by a method and a space (Eg. GET /book/:id).
+func RouteIs(routes ...string) Predicate {
+	return func(r *http.Request) bool {
+		pattern := RoutePattern(r)
+
+		for _, route := range routes {
+			if i := strings.IndexByte(route, ' '); i >= 0 {
+				if route[:i] != r.Method {
+					continue
+				}
+
+				route = route[i+1:]
+			}
+
+			if route == r.URL.Path || (pattern != "" && route == pattern) {
+				return true
+			}
+		}
+
+		return false
+	}
+}
+
+// Middleware with an explicit position in a chain.
+type ranked struct {
+	m        Middleware
+	priority int
+}
+
+// Rank each of m with priority.
+func rank(priority int, m []Middleware) []ranked {
+	r := make([]ranked, len(m))
+
+	for i, fn := range m {
+		r[i] = ranked{fn, priority}
+	}
+
+	return r
+}
+
+// Order middleware by ascending priority, keeping the order of middleware
+// with the same priority.
+func sortRanked(lists ...[]ranked) []Middleware {
+	var all []ranked
+
+	for _, list := range lists {
+		all = append(all, list...)
+	}
+
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].priority < all[j].priority
+	})
+
+	m := make([]Middleware, len(all))
+
+	for i, r := range all {
+		m[i] = r.m
+	}
+
+	return m
+}
@@ -0,0 +1,83 @@
+package uf
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestWhen(t *testing.T) {
+	s := NewServer(&Config{},
+		When(MethodIs(http.MethodPost), orderMiddleware("post")),
+		When(PathPrefix("/admin"), orderMiddleware("admin")),
+		Unless(PathIs("/health"), orderMiddleware("log")),
+	)
+
+	s.Get("/health", handleNothing)
+	s.Post("/admin/user", handleNothing)
+	s.Get("/admin/user", handleNothing)
+
+	tests := []struct {
+		method, path, expected string
+	}{
+		{http.MethodGet, "/health", ""},
+		{http.MethodPost, "/admin/user", "post,admin,log"},
+		{http.MethodGet, "/admin/user", "admin,log"},
+	}
+
+	for _, test := range tests {
+		if actual := order(s, test.method, test.path); actual != test.expected {
+			t.Errorf("%s %s: expected: %s, actual: %s", test.method, test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestExcept(t *testing.T) {
+	s := NewServer(&Config{}, Except(orderMiddleware("auth"), "/health", "POST /login", "/book/:id"))
+
+	s.Get("/health", handleNothing)
+	s.Get("/login", handleNothing)
+	s.Post("/login", handleNothing)
+	s.Get("/book/:id", handleNothing)
+	s.Get("/book", handleNothing)
+
+	tests := []struct {
+		method, path, expected string
+	}{
+		{http.MethodGet, "/health", ""},
+		{http.MethodGet, "/login", "auth"},
+		{http.MethodPost, "/login", ""},
+		{http.MethodGet, "/book/42", ""},
+		{http.MethodGet, "/book", "auth"},
+	}
+
+	for _, test := range tests {
+		if actual := order(s, test.method, test.path); actual != test.expected {
+			t.Errorf("%s %s: expected: %s, actual: %s", test.method, test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestMiddlewarePriority(t *testing.T) {
+	s := NewServer(&Config{}, orderMiddleware("global"))
+	s.AddGlobalMiddlewareAt(10, orderMiddleware("late"))
+
+	s.Get("/car", handleNothing, orderMiddleware("route")).MiddlewareAt(-1, orderMiddleware("first"))
+	s.NewGroup("/driver", orderMiddleware("group")).
+		MiddlewareAt(5, orderMiddleware("group5")).
+		Get(handleNothing, orderMiddleware("get"))
+
+	s.AddGlobalMiddlewareAt(-10, orderMiddleware("recover"))
+
+	tests := []struct {
+		method, path, expected string
+	}{
+		{http.MethodGet, "/car", "recover,first,global,route,late"},
+		{http.MethodGet, "/driver", "recover,global,group,get,group5,late"},
+	}
+
+	for _, test := range tests {
+		if actual := order(s, test.method, test.path); actual != test.expected {
+			t.Errorf("%s %s: expected: %s, actual: %s", test.method, test.path, test.expected, actual)
+		}
+	}
+}
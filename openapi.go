@@ -0,0 +1,409 @@
+package uf
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OpenAPI 3.1 document. Only the parts generated from the server are modelled.
+type OpenAPI struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components Components                       `json:"components"`
+
+	// component schema names of the struct types described
+	names map[reflect.Type]string
+}
+
+// Metadata about the API.
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+// Reusable schemas and parameters referenced by operations.
+type Components struct {
+	Schemas    map[string]*Schema    `json:"schemas"`
+	Parameters map[string]*Parameter `json:"parameters,omitempty"`
+}
+
+// A single method bound to a path.
+type Operation struct {
+	Summary     string               `json:"summary,omitempty"`
+	Tags        []string             `json:"tags,omitempty"`
+	Parameters  []Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody         `json:"requestBody,omitempty"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+// A path, query or header parameter of an operation.
+type Parameter struct {
+	Ref      string  `json:"$ref,omitempty"`
+	Name     string  `json:"name,omitempty"`
+	In       string  `json:"in,omitempty"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema,omitempty"`
+}
+
+// The body accepted by an operation.
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// A response sent by an operation.
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// The schema of a body for a single content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// A subset of JSON Schema as used by OpenAPI 3.1.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	ExclusiveMinimum     *float64           `json:"exclusiveMinimum,omitempty"`
+	ExclusiveMaximum     *float64           `json:"exclusiveMaximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	AnyOf                []*Schema          `json:"anyOf,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+
+	// null is accepted in addition to Type
+	nullable bool
+
+	// additionalProperties is false
+	closed bool
+}
+
+// Schema implements json.Unmarshaler in order to accept the forms of
+// "type" and "additionalProperties" found in hand-written documents: a type
+// may be a list including "null" (3.1) or accompanied by "nullable" (3.0),
+// and additionalProperties may be a boolean.
+func (s *Schema) UnmarshalJSON(b []byte) error {
+	type plain Schema
+	var raw struct {
+		plain
+		Type                 json.RawMessage `json:"type"`
+		AdditionalProperties json.RawMessage `json:"additionalProperties"`
+		Nullable             bool            `json:"nullable"`
+	}
+
+	if e := json.Unmarshal(b, &raw); e != nil {
+		return e
+	}
+
+	*s = Schema(raw.plain)
+	s.nullable = raw.Nullable
+
+	if len(raw.Type) > 0 && raw.Type[0] == '[' {
+		var types []string
+
+		if e := json.Unmarshal(raw.Type, &types); e != nil {
+			return e
+		}
+
+		for _, t := range types {
+			if t == "null" {
+				s.nullable = true
+			} else {
+				s.Type = t
+			}
+		}
+	} else if len(raw.Type) > 0 {
+		if e := json.Unmarshal(raw.Type, &s.Type); e != nil {
+			return e
+		}
+	}
+
+	switch ap := string(raw.AdditionalProperties); ap {
+	case "", "true":
+	case "false":
+		s.closed = true
+	default:
+		s.AdditionalProperties = &Schema{}
+
+		return json.Unmarshal(raw.AdditionalProperties, s.AdditionalProperties)
+	}
+
+	return nil
+}
+
+// Generate an OpenAPI document describing every route bound to the server.
+// Request and response bodies are described by the types supplied to
+// Route.Request and Route.Response; every operation may also respond with an HttpError.
+func (s *Server) OpenAPI(info OpenAPIInfo) *OpenAPI {
+	doc := &OpenAPI{
+		OpenAPI:    "3.1.0",
+		Info:       info,
+		Paths:      make(map[string]map[string]*Operation),
+		Components: Components{Schemas: make(map[string]*Schema)},
+		names:      make(map[reflect.Type]string),
+	}
+
+	errorSchema := doc.schema(reflect.TypeOf(HttpError{}))
+
+	for _, rt := range s.routes {
+		path, params := openAPIPath(rt.path)
+		op := &Operation{
+			Summary:    rt.summary,
+			Tags:       rt.tags,
+			Parameters: params,
+			Responses:  make(map[string]*Response),
+		}
+
+		if rt.request != nil {
+			op.RequestBody = &RequestBody{
+				Required: true,
+				Content:  jsonContent(doc.schema(reflect.TypeOf(rt.request))),
+			}
+		}
+
+		for code, v := range rt.responses {
+			res := &Response{Description: http.StatusText(code)}
+
+			if v != nil {
+				res.Content = jsonContent(doc.schema(reflect.TypeOf(v)))
+			}
+
+			op.Responses[strconv.Itoa(code)] = res
+		}
+
+		if len(rt.responses) == 0 {
+			op.Responses["200"] = &Response{Description: http.StatusText(http.StatusOK)}
+		}
+
+		op.Responses["default"] = &Response{Description: "Error", Content: jsonContent(errorSchema)}
+
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = make(map[string]*Operation)
+		}
+
+		doc.Paths[path][strings.ToLower(rt.method)] = op
+	}
+
+	return doc
+}
+
+// Write the document as indented JSON.
+func (doc *OpenAPI) Write(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+
+	return encoder.Encode(doc)
+}
+
+// Write the document to the file name. Useful for exporting the
+// document from a test.
+func (doc *OpenAPI) WriteFile(name string) error {
+	f, e := os.Create(name)
+
+	if e != nil {
+		return e
+	}
+
+	if e = doc.Write(f); e != nil {
+		f.Close()
+
+		return e
+	}
+
+	return f.Close()
+}
+
+// Bind a GET endpoint serving the document describing the server. The document
+// is generated on each request so that it includes routes bound later.
+func (s *Server) ServeOpenAPI(endpoint string, info OpenAPIInfo, m ...Middleware) *Route {
+	return s.Get(endpoint, func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, s.OpenAPI(info))
+	}, m...)
+}
+
+// Convert an httprouter path into an OpenAPI path template along with
+// its parameters. Eg. /book/:id/*file becomes /book/{id}/{file}.
+func openAPIPath(path string) (string, []Parameter) {
+	segments := strings.Split(path, "/")
+	var params []Parameter
+
+	for i, segment := range segments {
+		if len(segment) > 1 && (segment[0] == ':' || segment[0] == '*') {
+			name := segment[1:]
+			segments[i] = "{" + name + "}"
+			params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+		}
+	}
+
+	return strings.Join(segments, "/"), params
+}
+
+func jsonContent(schema *Schema) map[string]MediaType {
+	return map[string]MediaType{"application/json": {schema}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Describe t, adding named struct types to the document's components and
+// referencing them.
+func (doc *OpenAPI) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Kind() == reflect.Struct && t.Name() != "":
+		name, ok := doc.names[t]
+
+		if !ok {
+			name = doc.componentName(t)
+
+			// reserve the name first in case the type is recursive
+			doc.names[t] = name
+			doc.Components.Schemas[name] = nil
+			doc.Components.Schemas[name] = doc.structSchema(t)
+		}
+
+		return &Schema{Ref: "#/components/schemas/" + name}
+	case t.Kind() == reflect.Struct:
+		return doc.structSchema(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// encoding/json encodes byte slices as base64
+			return &Schema{Type: "string", Format: "byte"}
+		}
+
+		return &Schema{Type: "array", Items: doc.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: doc.schema(t.Elem())}
+	}
+
+	// interface{} and anything else accepts any value
+	return &Schema{}
+}
+
+var invalidComponentChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// Choose an unused component name for t: its name, qualified by as much of
+// its package path as needed if types from other packages share the name.
+// Eg. store.Book when api.Book was described first.
+func (doc *OpenAPI) componentName(t reflect.Type) string {
+	name := invalidComponentChars.ReplaceAllString(t.Name(), "_")
+	segments := strings.Split(t.PkgPath(), "/")
+	candidate := name
+
+	for i := len(segments) - 1; doc.taken(candidate); i-- {
+		if i < 0 {
+			// types of the same name in the same package, eg. declared in functions
+			for n := 2; doc.taken(candidate); n++ {
+				candidate = name + "_" + strconv.Itoa(n)
+			}
+
+			break
+		}
+
+		qualifier := invalidComponentChars.ReplaceAllString(strings.Join(segments[i:], "."), "_")
+		candidate = qualifier + "." + name
+	}
+
+	return candidate
+}
+
+func (doc *OpenAPI) taken(name string) bool {
+	_, ok := doc.Components.Schemas[name]
+
+	return ok
+}
+
+// Describe the fields of a struct as encoding/json would encode them.
+func (doc *OpenAPI) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, opts := f.Name, ""
+
+		if tag, ok := f.Tag.Lookup("json"); ok {
+			if tag == "-" {
+				continue
+			}
+
+			if i := strings.IndexByte(tag, ','); i >= 0 {
+				tag, opts = tag[:i], tag[i:]
+			}
+
+			if tag != "" {
+				name = tag
+			}
+		}
+
+		ft := f.Type
+
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+
+		if f.Anonymous && ft.Kind() == reflect.Struct && name == f.Name {
+			// promote the fields of embedded structs
+			embedded := doc.structSchema(ft)
+
+			for k, v := range embedded.Properties {
+				s.Properties[k] = v
+			}
+
+			s.Required = append(s.Required, embedded.Required...)
+
+			continue
+		}
+
+		if f.PkgPath != "" {
+			// unexported
+			continue
+		}
+
+		s.Properties[name] = doc.schema(f.Type)
+
+		if !strings.Contains(opts, "omitempty") && f.Type.Kind() != reflect.Ptr {
+			s.Required = append(s.Required, name)
+		}
+	}
+
+	return s
+}
@@ -0,0 +1,164 @@
+package uf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type Team struct {
+	Name    string    `json:"name"`
+	Founded time.Time `json:"founded"`
+	Cars    []GT1     `json:"cars,omitempty"`
+	Rival   *Team     `json:"rival"`
+	secret  string
+}
+
+func TestOpenAPIPath(t *testing.T) {
+	path, params := openAPIPath("/team/:name/file/*path")
+
+	if path != "/team/{name}/file/{path}" {
+		t.Errorf("unexpected path: %s", path)
+	}
+
+	if len(params) != 2 || params[0].Name != "name" || params[1].Name != "path" || !params[0].Required {
+		t.Errorf("unexpected parameters: %+v", params)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Get("/team/:name", handleNothing).Summary("Get a team", "teams").Response(http.StatusOK, Team{})
+	s.NewGroup("/team").Post(handleNothing).Route(http.MethodPost).Request(Team{}).Response(http.StatusCreated, nil)
+
+	doc := s.OpenAPI(OpenAPIInfo{Title: "Le Mans", Version: "1.0.0"})
+	get := doc.Paths["/team/{name}"]["get"]
+
+	if get == nil || get.Summary != "Get a team" || len(get.Parameters) != 1 {
+		t.Fatalf("unexpected operation: %+v", get)
+	}
+
+	if ref := get.Responses["200"].Content["application/json"].Schema.Ref; ref != "#/components/schemas/Team" {
+		t.Errorf("unexpected response schema: %s", ref)
+	}
+
+	if get.Responses["default"] == nil {
+		t.Error("HttpError response was not documented")
+	}
+
+	post := doc.Paths["/team"]["post"]
+
+	if post == nil || post.RequestBody == nil || post.Responses["201"] == nil {
+		t.Fatalf("unexpected operation: %+v", post)
+	}
+
+	team := doc.Components.Schemas["Team"]
+
+	if team == nil {
+		t.Fatal("Team schema was not generated")
+	}
+
+	if !reflect.DeepEqual(team.Required, []string{"name", "founded"}) {
+		t.Errorf("unexpected required properties: %v", team.Required)
+	}
+
+	if f := team.Properties["founded"]; f.Type != "string" || f.Format != "date-time" {
+		t.Errorf("unexpected time schema: %+v", f)
+	}
+
+	if cars := team.Properties["cars"]; cars.Type != "array" || cars.Items.Ref != "#/components/schemas/GT1" {
+		t.Errorf("unexpected slice schema: %+v", cars)
+	}
+
+	if rival := team.Properties["rival"]; rival.Ref != "#/components/schemas/Team" {
+		t.Errorf("unexpected recursive schema: %+v", rival)
+	}
+
+	if _, ok := team.Properties["secret"]; ok {
+		t.Error("unexported field was documented")
+	}
+
+	he := doc.Components.Schemas["HttpError"]
+
+	if _, ok := he.Properties["Header"]; ok || len(he.Properties) != 2 {
+		t.Errorf("unexpected HttpError schema: %+v", he.Properties)
+	}
+}
+
+// Shares its name with http.Cookie.
+type Cookie struct {
+	Flavour string `json:"flavour"`
+}
+
+// Returns a value of a type sharing its name with Cookie in the same package.
+func localCookie() interface{} {
+	type Cookie struct {
+		Crumbs int `json:"crumbs"`
+	}
+
+	return Cookie{}
+}
+
+func TestOpenAPINameCollision(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Get("/a", handleNothing).Response(http.StatusOK, http.Cookie{})
+	s.Get("/b", handleNothing).Response(http.StatusOK, []Cookie{})
+	s.Get("/c", handleNothing).Response(http.StatusOK, localCookie())
+
+	doc := s.OpenAPI(OpenAPIInfo{Title: "Le Mans", Version: "1.0.0"})
+	schemas := doc.Components.Schemas
+
+	if c := schemas["Cookie"]; c == nil || c.Properties["Name"] == nil {
+		t.Errorf("the first type described keeps its name: %+v", c)
+	}
+
+	if c := schemas["uf.Cookie"]; c == nil || c.Properties["flavour"] == nil {
+		t.Errorf("expected a qualified name for the second type: %+v", c)
+	}
+
+	// declared in the same package so qualified further
+	if c := schemas["blacksfk.uf.Cookie"]; c == nil || c.Properties["crumbs"] == nil {
+		t.Errorf("expected a further qualified name for the third type: %+v", c)
+	}
+
+	ref := doc.Paths["/b"]["get"].Responses["200"].Content["application/json"].Schema.Items.Ref
+
+	if ref != "#/components/schemas/uf.Cookie" {
+		t.Errorf("unexpected reference: %s", ref)
+	}
+}
+
+func TestServeOpenAPI(t *testing.T) {
+	s := NewServer(&Config{})
+	s.ServeOpenAPI("/openapi.json", OpenAPIInfo{Title: "Le Mans", Version: "1.0.0"})
+	s.Get("/team/:name", handleNothing)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+
+	var doc OpenAPI
+
+	if e := json.Unmarshal(recorder.Body.Bytes(), &doc); e != nil {
+		t.Fatal(e)
+	}
+
+	if doc.OpenAPI != "3.1.0" || len(doc.Paths) != 2 {
+		t.Errorf("unexpected document: %+v", doc)
+	}
+
+	// export to a file
+	name := filepath.Join(t.TempDir(), "openapi.json")
+
+	if e := s.OpenAPI(doc.Info).WriteFile(name); e != nil {
+		t.Fatal(e)
+	}
+
+	if info, e := os.Stat(name); e != nil || info.Size() == 0 {
+		t.Errorf("document was not written: %v", e)
+	}
+}
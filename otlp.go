@@ -0,0 +1,384 @@
+package uf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// OTLP exporter configuration.
+type OTLPOptions struct {
+	// URL spans are posted to. Defaults to http://localhost:4318/v1/traces,
+	// the traces endpoint of a local OpenTelemetry collector.
+	Endpoint string
+
+	// Headers added to every export request. Eg. authorization.
+	Headers map[string]string
+
+	// Client sending the export requests. Defaults to a client with a 10
+	// second timeout.
+	Client *http.Client
+
+	// Maximum number of spans per export request. Defaults to 512.
+	BatchSize int
+
+	// Maximum time spans wait before being exported. Defaults to 5 seconds.
+	Interval time.Duration
+
+	// Maximum number of spans waiting to be exported. Spans exported while
+	// the queue is full are dropped. Defaults to 2048.
+	QueueSize int
+
+	// Called with errors sending spans. Nil discards them.
+	ErrorLogger ErrorLogger
+}
+
+// Sends spans to an OpenTelemetry collector using OTLP/HTTP with JSON
+// encoding. Spans are batched and sent by a background goroutine so that
+// requests are not delayed by the collector:
+//
+//	exporter := uf.NewOTLPExporter(nil)
+//	defer exporter.Shutdown(context.Background())
+//
+//	server := uf.NewServer(&uf.Config{Tracer: uf.NewTracer("shop", exporter)})
+type OTLPExporter struct {
+	opts    OTLPOptions
+	mu      sync.Mutex
+	pending []*Span
+	closed  bool
+	flush   chan chan struct{}
+	wake    chan struct{}
+	done    chan struct{}
+}
+
+// Create an OTLP exporter and start its background goroutine. opts may be
+// nil for the defaults. Call Shutdown to send the remaining spans and stop it.
+func NewOTLPExporter(opts *OTLPOptions) *OTLPExporter {
+	oe := &OTLPExporter{
+		flush: make(chan chan struct{}),
+		wake:  make(chan struct{}, 1),
+		done:  make(chan struct{}),
+	}
+
+	if opts != nil {
+		oe.opts = *opts
+	}
+
+	if oe.opts.Endpoint == "" {
+		oe.opts.Endpoint = "http://localhost:4318/v1/traces"
+	}
+
+	if oe.opts.Client == nil {
+		oe.opts.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	if oe.opts.BatchSize <= 0 {
+		oe.opts.BatchSize = 512
+	}
+
+	if oe.opts.Interval <= 0 {
+		oe.opts.Interval = 5 * time.Second
+	}
+
+	if oe.opts.QueueSize <= 0 {
+		oe.opts.QueueSize = 2048
+	}
+
+	go oe.loop()
+
+	return oe
+}
+
+// Export implements Exporter. Queues the spans to be sent, returning an
+// error if some were dropped because the queue is full or the exporter has
+// been shut down.
+func (oe *OTLPExporter) Export(spans []*Span) error {
+	oe.mu.Lock()
+
+	if oe.closed {
+		oe.mu.Unlock()
+
+		return fmt.Errorf("uf: OTLP exporter is shut down; dropped %d spans", len(spans))
+	}
+
+	dropped := 0
+
+	if space := oe.opts.QueueSize - len(oe.pending); len(spans) > space {
+		dropped = len(spans) - space
+		spans = spans[:space]
+	}
+
+	oe.pending = append(oe.pending, spans...)
+
+	if len(oe.pending) >= oe.opts.BatchSize {
+		// send without waiting for the interval; under the lock as Shutdown closes wake
+		select {
+		case oe.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	oe.mu.Unlock()
+
+	if dropped > 0 {
+		return fmt.Errorf("uf: OTLP export queue is full; dropped %d spans", dropped)
+	}
+
+	return nil
+}
+
+// Send the queued spans and stop the background goroutine. Returns the
+// context's error if it is done first.
+func (oe *OTLPExporter) Shutdown(ctx context.Context) error {
+	oe.mu.Lock()
+
+	if !oe.closed {
+		oe.closed = true
+		close(oe.wake)
+	}
+
+	oe.mu.Unlock()
+
+	select {
+	case <-oe.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Send the queued spans, returning once they have been sent.
+func (oe *OTLPExporter) Flush() {
+	reply := make(chan struct{})
+
+	select {
+	case oe.flush <- reply:
+		<-reply
+	case <-oe.done:
+	}
+}
+
+func (oe *OTLPExporter) loop() {
+	defer close(oe.done)
+
+	ticker := time.NewTicker(oe.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case _, ok := <-oe.wake:
+			oe.send()
+
+			if !ok {
+				return
+			}
+		case reply := <-oe.flush:
+			oe.send()
+			close(reply)
+		case <-ticker.C:
+			oe.send()
+		}
+	}
+}
+
+// Send the queued spans in batches.
+func (oe *OTLPExporter) send() {
+	for {
+		oe.mu.Lock()
+		n := len(oe.pending)
+
+		if n > oe.opts.BatchSize {
+			n = oe.opts.BatchSize
+		}
+
+		batch := oe.pending[:n:n]
+		oe.pending = oe.pending[n:]
+		oe.mu.Unlock()
+
+		if n == 0 {
+			return
+		}
+
+		if e := oe.post(batch); e != nil && oe.opts.ErrorLogger != nil {
+			oe.opts.ErrorLogger(fmt.Errorf("uf: OTLP export: %v", e))
+		}
+	}
+}
+
+func (oe *OTLPExporter) post(spans []*Span) error {
+	body, e := json.Marshal(otlpRequest(spans))
+
+	if e != nil {
+		return e
+	}
+
+	req, e := http.NewRequest(http.MethodPost, oe.opts.Endpoint, bytes.NewReader(body))
+
+	if e != nil {
+		return e
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for k, v := range oe.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	res, e := oe.opts.Client.Do(req)
+
+	if e != nil {
+		return e
+	}
+
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body)
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("collector responded %s", res.Status)
+	}
+
+	return nil
+}
+
+// The OTLP/HTTP JSON encoding of the trace service's export request.
+// See https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding.
+type otlpExport struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	TraceState        string         `json:"traceState,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    SpanStatus `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"` // 64 bit integers are strings in JSON
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// Group spans by service into an export request.
+func otlpRequest(spans []*Span) otlpExport {
+	var export otlpExport
+	index := make(map[string]int)
+
+	for _, s := range spans {
+		i, ok := index[s.Service]
+
+		if !ok {
+			i = len(export.ResourceSpans)
+			index[s.Service] = i
+			export.ResourceSpans = append(export.ResourceSpans, otlpResourceSpans{
+				Resource: otlpResource{
+					Attributes: []otlpKeyValue{otlpAttribute("service.name", s.Service)},
+				},
+				ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/blacksfk/uf"}}},
+			})
+		}
+
+		ss := &export.ResourceSpans[i].ScopeSpans[0]
+		ss.Spans = append(ss.Spans, otlpSpanOf(s))
+	}
+
+	return export
+}
+
+func otlpSpanOf(s *Span) otlpSpan {
+	o := otlpSpan{
+		TraceID:           s.Context.TraceID.String(),
+		SpanID:            s.Context.SpanID.String(),
+		TraceState:        s.Context.TraceState,
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.StartTime.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+		Status:            otlpStatus{s.Status, s.StatusMessage},
+	}
+
+	if s.Parent.IsValid() {
+		o.ParentSpanID = s.Parent.String()
+	}
+
+	keys := make([]string, 0, len(s.Attributes))
+
+	for k := range s.Attributes {
+		keys = append(keys, k)
+	}
+
+	// deterministic output
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		o.Attributes = append(o.Attributes, otlpAttribute(k, s.Attributes[k]))
+	}
+
+	return o
+}
+
+func otlpAttribute(key string, v interface{}) otlpKeyValue {
+	var value otlpValue
+
+	switch v := v.(type) {
+	case string:
+		value.StringValue = &v
+	case bool:
+		value.BoolValue = &v
+	case int:
+		i := strconv.FormatInt(int64(v), 10)
+		value.IntValue = &i
+	case int64:
+		i := strconv.FormatInt(v, 10)
+		value.IntValue = &i
+	case float64:
+		value.DoubleValue = &v
+	default:
+		str := fmt.Sprint(v)
+		value.StringValue = &str
+	}
+
+	return otlpKeyValue{key, value}
+}
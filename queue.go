@@ -1,8 +1,12 @@
 package uf
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 	"net/http"
+	"runtime/debug"
+	"sync"
 	"time"
 )
 
@@ -14,13 +18,53 @@ import (
 type Queue struct {
 	c  Handler
 	m  []Middleware
+	rm []ranked // m excluding the global middleware
+
+	// the route served; nil for queues not bound by a server
+	route *Route
+
+	// container resolving values for requests; nil for queues not created by a server
+	services *Container
+
 	el ErrorLogger
 	al AccessLogger
+	cl *ConcurrencyLimiter
+	tl TimeoutLogger
+	co *CompressionOptions
+
+	// maximum duration of the request; zero or less for no limit
+	timeout time.Duration
+
+	// generate ETags and answer conditional GET requests
+	etags bool
+
+	// lifecycle hooks
+	h hooks
+
+	// request metrics; nil if not configured
+	metrics *Metrics
+
+	// creates spans for requests; nil if not configured
+	tracer *Tracer
 }
 
 // Create a new queue.
 func newQueue(c Handler, m []Middleware, config *Config) *Queue {
-	return &Queue{c, m, config.ErrorLogger, config.AccessLogger}
+	return &Queue{
+		c:       c,
+		m:       m,
+		rm:      rank(0, m),
+		el:      config.ErrorLogger,
+		al:      config.AccessLogger,
+		cl:      config.ConcurrencyLimiter,
+		tl:      config.TimeoutLogger,
+		co:      config.Compression,
+		timeout: config.Timeout,
+		etags:   config.ETags,
+		h:       newHooks(config),
+		metrics: config.Metrics,
+		tracer:  config.Tracer,
+	}
 }
 
 // Create a test queue in order to use and test the uf.Handler
@@ -38,19 +82,256 @@ func (q *Queue) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		defer q.logAccess(r, start)
 	}
 
+	if q.h.onResponse != nil || q.metrics != nil || q.tracer != nil {
+		// record what is sent once any compression has been applied
+		rw := &recordingWriter{ResponseWriter: w}
+		start := time.Now()
+
+		if q.tracer != nil {
+			var span *Span
+			r, span = q.tracer.startServer(r, q.pattern())
+
+			defer func() {
+				if e := span.finish(rw.status()); e != nil && q.el != nil {
+					q.el(fmt.Errorf("export spans: %v", e))
+				}
+			}()
+		}
+
+		var finish func(int, int64, time.Duration)
+
+		if q.metrics != nil {
+			finish = q.metrics.start(r, q.pattern())
+		}
+
+		defer func() {
+			info := ResponseInfo{rw.status(), rw.size, time.Since(start)}
+
+			if finish != nil {
+				finish(info.Status, info.Size, info.Duration)
+			}
+
+			if q.h.onResponse != nil {
+				q.h.onResponse(r, info)
+			}
+		}()
+
+		w = rw
+	}
+
+	var cw *compressWriter
+
+	if q.co != nil {
+		// compression was configured and the client accepts it
+		if cw = newCompressWriter(w, r, q.co); cw != nil {
+			defer cw.Close()
+
+			w = cw
+		}
+	}
+
+	if q.etags && (r.Method == http.MethodGet || r.Method == http.MethodHead) {
+		// buffer the response to tag it once the handler has finished
+		ew := &etagWriter{ResponseWriter: w, r: r, cw: cw}
+		defer ew.finish()
+
+		w = ew
+	}
+
+	if q.timeout > 0 {
+		q.runTimeout(w, r)
+	} else {
+		q.run(w, r)
+	}
+}
+
+// The pattern of the route served. Empty if the queue does not serve a route.
+func (q *Queue) pattern() string {
+	if q.route == nil {
+		return ""
+	}
+
+	return q.route.path
+}
+
+// Serve the request, send any error returned, and call the deferred functions.
+func (q *Queue) run(w http.ResponseWriter, r *http.Request) {
+	s := &state{header: w.Header(), route: q.route, services: q.services, el: q.el}
+	r = withState(r, s)
+
+	var e error
+	completed := false
+
+	if q.h.onPanic != nil {
+		// registered first in order to run after the deferred functions
+		rw := &recordingWriter{ResponseWriter: w}
+		w = rw
+
+		defer q.recover(rw, r)
+	}
+
+	defer func() {
+		if !completed {
+			// the panic continues once the deferred functions have been called
+			e = ErrPanicked
+			SpanFromContext(r.Context()).SetError(e)
+		}
+
+		s.done(e)
+
+		if de := s.dispose(); de != nil && q.el != nil {
+			q.el(fmt.Errorf("dispose: %v", de))
+		}
+	}()
+
+	if q.h.onRequest != nil {
+		q.h.onRequest(r)
+	}
+
+	if e = q.serve(w, r); e != nil {
+		if q.h.onError != nil {
+			q.h.onError(r, e)
+		}
+
+		q.handleError(w, e)
+	}
+
+	completed = true
+}
+
+// Recover a panic in a middleware or handler, supplying it to Config.OnPanic
+// and sending a 500 Internal Server Error if nothing was written.
+func (q *Queue) recover(rw *recordingWriter, r *http.Request) {
+	p := recover()
+
+	if p == nil {
+		return
+	}
+
+	if p == http.ErrAbortHandler {
+		// the handler deliberately aborted the response
+		panic(p)
+	}
+
+	q.h.onPanic(r, p, debug.Stack())
+
+	if rw.code == 0 {
+		q.handleError(rw, InternalServerError("Internal Server Error"))
+	}
+}
+
+// Run the middleware and the controller function. Terminates early
+// if an error was returned.
+func (q *Queue) serve(w http.ResponseWriter, r *http.Request) error {
+	if q.cl != nil {
+		// server-wide concurrency limit applies before any middleware
+		if e := q.cl.acquire(r); e != nil {
+			return e
+		}
+	}
+
 	// loop through the middleware provided
-	// terminate early if an error was returned
 	for _, m := range q.m {
-		if e := m(r); e != nil {
-			q.handleError(w, e)
+		var span *Span
 
-			return
+		if q.tracer != nil {
+			span = startFuncSpan(r, m)
+		}
+
+		e := m(r)
+		endFuncSpan(span, e)
+
+		if e != nil {
+			return e
+		}
+
+		r = advance(r)
+	}
+
+	if q.h.onHandler != nil {
+		q.h.onHandler(r)
+	}
+
+	// wrapped by the middleware, eg. Transaction
+	c := stateFrom(r).wrap(q.c)
+
+	if q.tracer != nil {
+		if span := startFuncSpan(r, q.c); span != nil {
+			// spans started by the handler are children of its span
+			e := c(w, r.WithContext(context.WithValue(r.Context(), spanKey{}, span)))
+			endFuncSpan(span, e)
+
+			return e
 		}
 	}
 
 	// run the controller function
-	if e := q.c(w, r); e != nil {
-		q.handleError(w, e)
+	return c(w, r)
+}
+
+// Run the queue with a context deadline. The response is buffered so that
+// a handler still running after the deadline cannot write to w.
+func (q *Queue) runTimeout(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), q.timeout)
+	defer cancel()
+
+	// headers already set (eg. Allow by the router) are seen by the handler
+	tw := &timeoutWriter{ctx: ctx, header: w.Header().Clone()}
+	done := make(chan struct{})
+	panicked := make(chan interface{}, 1)
+	inTime := false
+
+	go func() {
+		defer func() {
+			if p := recover(); p != nil {
+				panicked <- p
+			}
+		}()
+
+		q.run(tw, r.WithContext(ctx))
+
+		// read once done is closed
+		inTime = ctx.Err() == nil
+		close(done)
+	}()
+
+	select {
+	case p := <-panicked:
+		// re-panic on the serving goroutine
+		panic(p)
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	// both may be ready whichever case was selected, so the response is only
+	// sent if the queue finished before the deadline
+	select {
+	case <-done:
+		if inTime {
+			tw.copyTo(w)
+
+			return
+		}
+	default:
+	}
+
+	tw.expire()
+
+	if ctx.Err() != context.DeadlineExceeded {
+		// the client went away; nobody to respond to
+		return
+	}
+
+	he := ServiceUnavailable(fmt.Sprintf("Request timed out after %v", q.timeout))
+
+	if q.tl != nil {
+		q.tl(r, q.timeout)
+	} else if q.el != nil {
+		q.el(he)
+	}
+
+	if e := SendErrorJSON(w, he); e != nil && q.el != nil {
+		q.el(fmt.Errorf("SendErrorJSON(): %v", e))
 	}
 }
 
@@ -78,24 +359,95 @@ func (q *Queue) logAccess(r *http.Request, start time.Time) {
 }
 
 func (q *Queue) handleError(w http.ResponseWriter, e error) {
-	// check if e is already an http error
-	httpError, ok := e.(HttpError)
+	// check if e is or wraps an http error
+	httpError, ok := AsHttpError(e)
 
 	if !ok {
-		// create an HttpError out of the plain error
-		httpError = InternalServerError(e.Error())
+		// the message of a plain error is not meant for the client
+		httpError = WrapInternal(e)
+		e = httpError
 	}
 
-	// log the error to the supplied function
+	// log the error, including any context wrapping the http error, to the
+	// supplied function; the client receives only the http error
 	if q.el != nil {
-		q.el(httpError)
+		q.el(e)
 	}
 
 	// send the error to the client
 	e = SendErrorJSON(w, httpError)
 
-	if e != nil {
+	if e != nil && q.el != nil {
 		// something went incredibly wrong...
 		q.el(fmt.Errorf("SendErrorJSON(): %v", e))
 	}
 }
+
+// Buffers a response until the handler finishes within its deadline.
+type timeoutWriter struct {
+	ctx     context.Context
+	mutex   sync.Mutex
+	header  http.Header
+	buf     bytes.Buffer
+	code    int
+	expired bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+func (tw *timeoutWriter) Write(b []byte) (int, error) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if tw.expired || tw.ctx.Err() != nil {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+
+	return tw.buf.Write(b)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	if !tw.expired && tw.code == 0 {
+		tw.code = code
+	}
+}
+
+// Prevent any further writes.
+func (tw *timeoutWriter) expire() {
+	tw.mutex.Lock()
+	defer tw.mutex.Unlock()
+
+	tw.expired = true
+}
+
+// Write the buffered response to w.
+func (tw *timeoutWriter) copyTo(w http.ResponseWriter) {
+	h := w.Header()
+
+	for k := range h {
+		if _, ok := tw.header[k]; !ok {
+			// removed by the handler
+			delete(h, k)
+		}
+	}
+
+	for k, v := range tw.header {
+		h[k] = v
+	}
+
+	if tw.code == 0 {
+		tw.code = http.StatusOK
+	}
+
+	w.WriteHeader(tw.code)
+	w.Write(tw.buf.Bytes())
+}
@@ -9,7 +9,9 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestQueue(t *testing.T) {
@@ -73,6 +75,77 @@ func TestHandleError(t *testing.T) {
 	}
 }
 
+func TestHandleWrappedError(t *testing.T) {
+	cause := errors.New("dial tcp 10.0.0.7:5432: connection refused")
+	var logged []error
+
+	q := Queue{el: func(e error) { logged = append(logged, e) }}
+
+	tests := []struct {
+		e       error
+		code    int
+		message string
+	}{
+		{fmt.Errorf("load car: %w", NotFound("No such car")), http.StatusNotFound, "No such car"},
+		{cause, http.StatusInternalServerError, "Internal Server Error"},
+		{Wrap(cause, http.StatusBadGateway, "The database is unavailable"), http.StatusBadGateway, "The database is unavailable"},
+		{fmt.Errorf("load car: %w", WrapInternal(cause)), http.StatusInternalServerError, "Internal Server Error"},
+		{Forbidden("Not your car").WithCause(cause), http.StatusForbidden, "Not your car"},
+	}
+
+	for i, test := range tests {
+		recorder := httptest.NewRecorder()
+		q.handleError(recorder, test.e)
+		body := recorder.Body.String()
+
+		var he HttpError
+
+		if e := json.Unmarshal(recorder.Body.Bytes(), &he); e != nil {
+			t.Fatal(e)
+		}
+
+		if recorder.Code != test.code || he.Message != test.message {
+			t.Errorf("%d: expected %d %q; got %d %q", i, test.code, test.message, recorder.Code, he.Message)
+		}
+
+		if strings.Contains(body, "refused") {
+			t.Errorf("%d: the cause must not be sent to the client: %s", i, body)
+		}
+	}
+
+	// the causes are logged
+	for _, i := range []int{1, 2, 3, 4} {
+		if !errors.Is(logged[i], cause) || !strings.Contains(logged[i].Error(), "refused") {
+			t.Errorf("%d: expected the cause to be logged; got %v", i, logged[i])
+		}
+	}
+
+	// wrapped http errors are logged as they are
+	expected := map[int]string{
+		0: "load car: 404 Not Found: No such car",
+		3: "load car: 500 Internal Server Error: Internal Server Error: " + cause.Error(),
+	}
+
+	for i, s := range expected {
+		if logged[i].Error() != s {
+			t.Errorf("%d: expected %q to be logged; got %q", i, s, logged[i])
+		}
+	}
+
+	// no error logger
+	q = Queue{}
+	q.handleError(&failingWriter{httptest.NewRecorder()}, cause)
+}
+
+// Fails every write.
+type failingWriter struct {
+	http.ResponseWriter
+}
+
+func (fw *failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
 type Character struct {
 	name string
 	wins float64
@@ -130,3 +203,71 @@ func controller(w http.ResponseWriter, r *http.Request) error {
 
 	return nil
 }
+
+func TestQueueTimeout(t *testing.T) {
+	written := make(chan error, 1)
+	logged := false
+	config := &Config{
+		Timeout: 10 * time.Millisecond,
+		TimeoutLogger: func(r *http.Request, d time.Duration) {
+			logged = true
+		},
+		ErrorLogger: func(e error) {
+			t.Errorf("timeout logged as an error: %v", e)
+		},
+	}
+
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		<-r.Context().Done()
+
+		// writing after the deadline must fail rather than race the timeout response
+		_, e := w.Write([]byte("late"))
+		written <- e
+
+		return nil
+	}, nil, config)
+
+	recorder := httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected: 503, actual: %d", recorder.Code)
+	}
+
+	if !logged {
+		t.Error("TimeoutLogger was not called")
+	}
+
+	if e := <-written; e != http.ErrHandlerTimeout {
+		t.Errorf("expected: %v, actual: %v", http.ErrHandlerTimeout, e)
+	}
+}
+
+func TestQueueWithinTimeout(t *testing.T) {
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		if _, ok := r.Context().Deadline(); !ok {
+			t.Error("request context has no deadline")
+		}
+
+		w.Header().Set("X-Driver", "Senna")
+
+		return SendJSON(w, GT1{"McLaren", "F1 GTR", 1995})
+	}, nil, &Config{Timeout: time.Minute})
+
+	recorder := httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("expected: 200, actual: %d", recorder.Code)
+	}
+
+	if v := recorder.Header().Get("X-Driver"); v != "Senna" {
+		t.Errorf("buffered header was not copied: %q", v)
+	}
+
+	car := GT1{}
+
+	if e := json.Unmarshal(recorder.Body.Bytes(), &car); e != nil || car.Debut != 1995 {
+		t.Errorf("buffered body was not copied: %v %+v", e, car)
+	}
+}
@@ -0,0 +1,314 @@
+package uf
+
+import (
+	"hash/fnv"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Outcome of taking a single request from a rate limit.
+type RateResult struct {
+	Allowed bool
+
+	// Maximum number of requests allowed by the limit.
+	Limit int
+
+	// Number of requests remaining after this one.
+	Remaining int
+
+	// Time until the limit is fully replenished.
+	Reset time.Duration
+
+	// Time until the next request will be allowed. Zero when Allowed is true.
+	RetryAfter time.Duration
+}
+
+// State stored per key by a RateStore. The fields are interpreted
+// by the RateAlgorithm that produced them; a zero RateState is a fresh key.
+type RateState struct {
+	Value float64
+	Count int64
+	Stamp time.Time
+}
+
+// Algorithms decide whether a request is allowed given the state of its key.
+type RateAlgorithm interface {
+	// Take a single request from s at time now, returning the updated state.
+	Take(s RateState, now time.Time) (RateState, RateResult)
+
+	// Idle duration after which a key's state is equivalent to a fresh one
+	// and may be evicted.
+	TTL() time.Duration
+}
+
+// Stores rate limit state per key. Implementations must apply the algorithm
+// atomically with respect to other calls for the same key.
+type RateStore interface {
+	Take(key string, a RateAlgorithm, now time.Time) RateResult
+}
+
+// Functions implementing this type extract the key requests are limited by.
+// Requests with an empty key are not limited.
+type KeyFunc func(*http.Request) string
+
+// Key requests by the client's IP address.
+func KeyByIP(r *http.Request) string {
+	host, _, e := net.SplitHostPort(r.RemoteAddr)
+
+	if e != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// Key requests by the value of the header name. Eg. X-Api-Key.
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// Token bucket algorithm. Refills Limit tokens every Interval up to a
+// maximum of Burst tokens (or Limit if Burst is zero). Each request takes one token.
+type TokenBucket struct {
+	Limit    int
+	Interval time.Duration
+	Burst    int
+}
+
+func (tb TokenBucket) capacity() float64 {
+	if tb.Burst > 0 {
+		return float64(tb.Burst)
+	}
+
+	return float64(tb.Limit)
+}
+
+// tokens per nanosecond
+func (tb TokenBucket) rate() float64 {
+	return float64(tb.Limit) / float64(tb.Interval)
+}
+
+// TokenBucket implements RateAlgorithm.
+func (tb TokenBucket) Take(s RateState, now time.Time) (RateState, RateResult) {
+	capacity := tb.capacity()
+	rate := tb.rate()
+	tokens := capacity
+
+	if !s.Stamp.IsZero() {
+		// refill the bucket based on the time since the last request
+		tokens = math.Min(capacity, s.Value+float64(now.Sub(s.Stamp))*rate)
+	}
+
+	res := RateResult{Limit: int(capacity)}
+
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration(math.Ceil((1 - tokens) / rate))
+	}
+
+	res.Remaining = int(tokens)
+	res.Reset = time.Duration(math.Ceil((capacity - tokens) / rate))
+
+	return RateState{Value: tokens, Stamp: now}, res
+}
+
+// TokenBucket implements RateAlgorithm.
+func (tb TokenBucket) TTL() time.Duration {
+	return time.Duration(tb.capacity() / tb.rate())
+}
+
+// Sliding window algorithm. Allows Limit requests in any Window, estimating
+// the count from the current and previous fixed windows.
+type SlidingWindow struct {
+	Limit  int
+	Window time.Duration
+}
+
+// SlidingWindow implements RateAlgorithm.
+func (sw SlidingWindow) Take(s RateState, now time.Time) (RateState, RateResult) {
+	start := now.Truncate(sw.Window)
+
+	switch {
+	case s.Stamp.Equal(start):
+		// still in the same window
+	case s.Stamp.Add(sw.Window).Equal(start):
+		// moved into the next window
+		s = RateState{Value: float64(s.Count), Stamp: start}
+	default:
+		// idle for at least a whole window
+		s = RateState{Stamp: start}
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(sw.Window)
+	estimate := s.Value*weight + float64(s.Count)
+	limit := float64(sw.Limit)
+	res := RateResult{Limit: sw.Limit, Reset: sw.Window - elapsed}
+
+	if estimate+1 <= limit {
+		s.Count++
+		res.Allowed = true
+		res.Remaining = int(limit - estimate - 1)
+
+		return s, res
+	}
+
+	if free := limit - 1 - float64(s.Count); free >= 0 && s.Value > 0 {
+		// wait until enough of the previous window has slid out
+		res.RetryAfter = time.Duration((1-free/s.Value)*float64(sw.Window)) - elapsed
+	} else {
+		// the current window alone is full
+		res.RetryAfter = res.Reset
+	}
+
+	return s, res
+}
+
+// SlidingWindow implements RateAlgorithm.
+func (sw SlidingWindow) TTL() time.Duration {
+	return 2 * sw.Window
+}
+
+// In-memory RateStore. Keys are spread across shards to reduce lock
+// contention, and idle keys are evicted as the shards are accessed.
+type MemoryRateStore struct {
+	shards []rateShard
+	max    int
+}
+
+type rateShard struct {
+	sync.Mutex
+	entries map[string]rateEntry
+	sweep   time.Time
+}
+
+type rateEntry struct {
+	state   RateState
+	expires time.Time
+}
+
+// Create a MemoryRateStore with the number of shards, each holding
+// at most maxKeys keys. When a shard is full the key closest to expiry is evicted.
+func NewMemoryRateStore(shards, maxKeys int) *MemoryRateStore {
+	if shards < 1 {
+		shards = 1
+	}
+
+	s := &MemoryRateStore{make([]rateShard, shards), maxKeys}
+
+	for i := range s.shards {
+		s.shards[i].entries = make(map[string]rateEntry)
+	}
+
+	return s
+}
+
+// MemoryRateStore implements RateStore.
+func (s *MemoryRateStore) Take(key string, a RateAlgorithm, now time.Time) RateResult {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+
+	shard := &s.shards[h.Sum32()%uint32(len(s.shards))]
+	shard.Lock()
+	defer shard.Unlock()
+
+	entry, ok := shard.entries[key]
+
+	if !ok || now.After(entry.expires) {
+		entry = rateEntry{}
+
+		if now.After(shard.sweep) {
+			shard.evictExpired(now)
+			shard.sweep = now.Add(a.TTL())
+		}
+
+		if s.max > 0 && len(shard.entries) >= s.max {
+			shard.evictOldest()
+		}
+	}
+
+	var res RateResult
+	entry.state, res = a.Take(entry.state, now)
+	entry.expires = now.Add(a.TTL())
+	shard.entries[key] = entry
+
+	return res
+}
+
+func (s *rateShard) evictExpired(now time.Time) {
+	for k, e := range s.entries {
+		if now.After(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+func (s *rateShard) evictOldest() {
+	var oldest string
+	var expires time.Time
+
+	for k, e := range s.entries {
+		if expires.IsZero() || e.expires.Before(expires) {
+			oldest = k
+			expires = e.expires
+		}
+	}
+
+	delete(s.entries, oldest)
+}
+
+// Limit requests by key using an in-memory store. Each call creates an
+// independent limit, so the returned middleware can be applied globally,
+// to a group, or to a single route.
+func RateLimit(a RateAlgorithm, key KeyFunc) Middleware {
+	return RateLimitWith(NewMemoryRateStore(16, 4096), a, key)
+}
+
+// Limit requests by key using the supplied store. Allowed requests have the
+// RateLimit-* headers set on the response; rejected requests return a
+// 429 Too Many Requests error with the same headers and Retry-After.
+func RateLimitWith(store RateStore, a RateAlgorithm, key KeyFunc) Middleware {
+	return func(r *http.Request) error {
+		k := key(r)
+
+		if k == "" {
+			return nil
+		}
+
+		res := store.Take(k, a, time.Now())
+
+		if res.Allowed {
+			setRateLimitHeaders(ResponseHeader(r), res)
+
+			return nil
+		}
+
+		h := http.Header{}
+		setRateLimitHeaders(h, res)
+		h.Set("Retry-After", seconds(res.RetryAfter))
+
+		he := TooManyRequests("Rate limit exceeded")
+		he.header = &h
+
+		return he
+	}
+}
+
+func setRateLimitHeaders(h http.Header, res RateResult) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", seconds(res.Reset))
+}
+
+// Format d as a whole number of seconds, rounding up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
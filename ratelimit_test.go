@@ -0,0 +1,156 @@
+package uf
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	tb := TokenBucket{Limit: 2, Interval: time.Second}
+	now := time.Now()
+	var s RateState
+	var res RateResult
+
+	// the bucket starts full
+	for i := 0; i < 2; i++ {
+		s, res = tb.Take(s, now)
+
+		if !res.Allowed {
+			t.Fatalf("request %d: expected to be allowed: %+v", i, res)
+		}
+	}
+
+	s, res = tb.Take(s, now)
+
+	if res.Allowed {
+		t.Fatalf("expected the bucket to be empty: %+v", res)
+	}
+
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("RetryAfter: expected: 500ms, actual: %v", res.RetryAfter)
+	}
+
+	// half a second refills a single token
+	_, res = tb.Take(s, now.Add(500*time.Millisecond))
+
+	if !res.Allowed {
+		t.Errorf("expected the bucket to have refilled: %+v", res)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	sw := SlidingWindow{Limit: 2, Window: time.Minute}
+	start := time.Now().Truncate(time.Minute)
+	var s RateState
+	var res RateResult
+
+	for i := 0; i < 2; i++ {
+		s, res = sw.Take(s, start)
+
+		if !res.Allowed {
+			t.Fatalf("request %d: expected to be allowed: %+v", i, res)
+		}
+	}
+
+	s, res = sw.Take(s, start.Add(time.Second))
+
+	if res.Allowed {
+		t.Fatalf("expected the window to be full: %+v", res)
+	}
+
+	// a quarter into the next window the previous window still weighs 1.5
+	s, res = sw.Take(s, start.Add(75*time.Second))
+
+	if res.Allowed {
+		t.Fatalf("expected the previous window to count: %+v", res)
+	}
+
+	// halfway the previous window weighs 1 allowing a single request
+	_, res = sw.Take(s, start.Add(90*time.Second))
+
+	if !res.Allowed {
+		t.Errorf("expected the window to have slid: %+v", res)
+	}
+}
+
+func TestMemoryRateStoreEviction(t *testing.T) {
+	store := NewMemoryRateStore(1, 2)
+	tb := TokenBucket{Limit: 1, Interval: time.Second}
+	now := time.Now()
+
+	store.Take("a", tb, now)
+	store.Take("b", tb, now.Add(time.Millisecond))
+	store.Take("c", tb, now.Add(2*time.Millisecond))
+
+	entries := store.shards[0].entries
+
+	if len(entries) != 2 {
+		t.Fatalf("expected 2 keys, actual: %d", len(entries))
+	}
+
+	if _, ok := entries["a"]; ok {
+		t.Errorf("expected the oldest key to be evicted: %+v", entries)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	m := []Middleware{RateLimit(TokenBucket{Limit: 1, Interval: time.Minute}, KeyByHeader("X-Api-Key"))}
+	q := newQueue(handleNothing, m, &Config{})
+
+	send := func(key string) *http.Response {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Api-Key", key)
+		q.ServeHTTP(recorder, r)
+
+		return recorder.Result()
+	}
+
+	res := send("a")
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected: 200, actual: %d", res.StatusCode)
+	}
+
+	if v := res.Header.Get("RateLimit-Remaining"); v != "0" {
+		t.Errorf("RateLimit-Remaining: expected: 0, actual: %q", v)
+	}
+
+	res = send("a")
+
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected: 429, actual: %d", res.StatusCode)
+	}
+
+	if v := res.Header.Get("Retry-After"); v != "60" {
+		t.Errorf("Retry-After: expected: 60, actual: %q", v)
+	}
+
+	// other keys are limited independently
+	if res = send("b"); res.StatusCode != http.StatusOK {
+		t.Errorf("expected: 200, actual: %d", res.StatusCode)
+	}
+}
+
+func TestHttpErrorComparable(t *testing.T) {
+	errNoCar := NotFound("No such car")
+
+	if !errors.Is(fmt.Errorf("load car: %w", NotFound("No such car")), errNoCar) {
+		t.Error("errors.Is must match a sentinel HttpError")
+	}
+
+	he := TooManyRequests("Slow down").WithHeader("Retry-After", "1")
+	again := he.WithHeader("RateLimit-Limit", "1")
+
+	if he.Header().Get("RateLimit-Limit") != "" || again.Header().Get("Retry-After") != "1" {
+		t.Errorf("WithHeader must copy the headers: %v %v", he.Header(), again.Header())
+	}
+
+	if errors.Is(he, TooManyRequests("Slow down")) {
+		t.Error("errors with different headers must not be equal")
+	}
+}
@@ -0,0 +1,275 @@
+package uf
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// A route bound to the server. Returned by the binding methods in order
+// to configure the route further.
+type Route struct {
+	method  string
+	path    string
+	name    string
+	handler Handler
+	queue   *Queue
+	server  *Server
+	meta    map[string]interface{}
+
+	// documentation
+	summary   string
+	tags      []string
+	request   interface{}
+	responses map[int]interface{}
+}
+
+// Description of a bound route.
+type RouteInfo struct {
+	Method     string                 `json:"method"`
+	Path       string                 `json:"path"`
+	Name       string                 `json:"name,omitempty"`
+	Handler    string                 `json:"handler"`
+	Middleware []string               `json:"middleware"`
+	Meta       map[string]interface{} `json:"meta,omitempty"`
+}
+
+// Override Config.Timeout for this route. A negative duration disables the timeout.
+func (rt *Route) Timeout(d time.Duration) *Route {
+	rt.queue.timeout = d
+
+	return rt
+}
+
+// Name the route in order to generate URLs for it with Server.URL. Routes
+// bound to the same path for different methods may share a name.
+func (rt *Route) Name(name string) *Route {
+	rt.name = name
+
+	return rt
+}
+
+// Add middleware to the route after the middleware it was bound with.
+func (rt *Route) Middleware(m ...Middleware) *Route {
+	return rt.MiddlewareAt(0, m...)
+}
+
+// Add middleware to the route with an explicit priority. See
+// Server.AddGlobalMiddlewareAt for how priorities order the chain.
+func (rt *Route) MiddlewareAt(priority int, m ...Middleware) *Route {
+	rt.use(rank(priority, m))
+
+	return rt
+}
+
+// Append ranked middleware to the route's own and resolve its chain again.
+func (rt *Route) use(m []ranked) {
+	q := rt.queue
+	q.rm = append(q.rm[:len(q.rm):len(q.rm)], m...)
+
+	if rt.server != nil {
+		rt.server.resolve(q)
+	} else {
+		q.m = sortRanked(q.rm)
+	}
+}
+
+// Attach arbitrary metadata to the route. Eg. a description or owning team.
+func (rt *Route) Meta(key string, value interface{}) *Route {
+	if rt.meta == nil {
+		rt.meta = make(map[string]interface{})
+	}
+
+	rt.meta[key] = value
+
+	return rt
+}
+
+// Describe the route in generated documentation.
+func (rt *Route) Summary(summary string, tags ...string) *Route {
+	rt.summary = summary
+	rt.tags = tags
+
+	return rt
+}
+
+// Document the type of the request body by example. Eg. Book{}.
+func (rt *Route) Request(v interface{}) *Route {
+	rt.request = v
+
+	return rt
+}
+
+// Document the type of the response body sent with code by example. Eg.
+// []Book{}. A nil v documents a response without a body.
+func (rt *Route) Response(code int, v interface{}) *Route {
+	if rt.responses == nil {
+		rt.responses = make(map[int]interface{})
+	}
+
+	rt.responses[code] = v
+
+	return rt
+}
+
+// Describe the route.
+func (rt *Route) Info() RouteInfo {
+	info := RouteInfo{
+		Method:     rt.method,
+		Path:       rt.path,
+		Name:       rt.name,
+		Handler:    funcName(rt.handler),
+		Middleware: make([]string, len(rt.queue.m)),
+		Meta:       rt.meta,
+	}
+
+	for i, m := range rt.queue.m {
+		info.Middleware[i] = funcName(m)
+	}
+
+	return info
+}
+
+// Describe every route bound to the server in registration order,
+// including those bound through groups.
+func (s *Server) Routes() []RouteInfo {
+	routes := make([]RouteInfo, len(s.routes))
+
+	for i, rt := range s.routes {
+		routes[i] = rt.Info()
+	}
+
+	return routes
+}
+
+// Find the most recently bound route for method and path.
+func (s *Server) route(method, path string) *Route {
+	for i := len(s.routes) - 1; i >= 0; i-- {
+		if rt := s.routes[i]; rt.method == method && rt.path == path {
+			return rt
+		}
+	}
+
+	return nil
+}
+
+// Get the pattern of the route matched for r. Eg. /book/:id. Returns an
+// empty string for requests not matching a route or not served by a Queue.
+func RoutePattern(r *http.Request) string {
+	if s := stateFrom(r); s != nil && s.route != nil {
+		return s.route.path
+	}
+
+	return ""
+}
+
+// Generate the URL of the route named name, filling its :param and
+// *catchall segments with the values of the params of the same key.
+// Values are escaped; params not used by the path are appended as the query string.
+// An error is returned if the name is unknown or a path parameter is missing.
+//
+//	s.Get("/book/:id", book.HandleGet).Name("book")
+//
+//	// ...
+//
+//	// /book/42?format=pdf
+//	link, e := s.URL("book", uf.Param{Key: "id", Value: "42"}, uf.Param{Key: "format", Value: "pdf"})
+func (s *Server) URL(name string, params ...Param) (string, error) {
+	var rt *Route
+
+	for i := len(s.routes) - 1; i >= 0 && rt == nil; i-- {
+		if s.routes[i].name == name {
+			rt = s.routes[i]
+		}
+	}
+
+	if rt == nil {
+		return "", fmt.Errorf("no route named %q", name)
+	}
+
+	values := make(map[string]string, len(params))
+	used := make(map[string]bool, len(params))
+
+	for _, p := range params {
+		values[p.Key] = p.Value
+	}
+
+	segments := strings.Split(rt.path, "/")
+
+	for i, segment := range segments {
+		if len(segment) < 2 || (segment[0] != ':' && segment[0] != '*') {
+			continue
+		}
+
+		key := segment[1:]
+		value, ok := values[key]
+
+		if !ok {
+			return "", fmt.Errorf("route %q: missing parameter %q", name, key)
+		}
+
+		used[key] = true
+
+		if segment[0] == ':' {
+			if value == "" {
+				return "", fmt.Errorf("route %q: parameter %q is empty", name, key)
+			}
+
+			segments[i] = url.PathEscape(value)
+
+			continue
+		}
+
+		// catch-all values span segments and, as with httprouter, may begin with a slash
+		parts := strings.Split(strings.TrimPrefix(value, "/"), "/")
+
+		for j, part := range parts {
+			parts[j] = url.PathEscape(part)
+		}
+
+		segments[i] = strings.Join(parts, "/")
+	}
+
+	query := url.Values{}
+
+	for _, p := range params {
+		if !used[p.Key] {
+			query.Add(p.Key, p.Value)
+		}
+	}
+
+	link := strings.Join(segments, "/")
+
+	if len(query) > 0 {
+		link += "?" + query.Encode()
+	}
+
+	return link, nil
+}
+
+// Handler sending the route table as JSON. Bind it to expose the table
+// for debugging, preferably behind authentication middleware:
+//
+//	server.Get("/debug/routes", server.HandleRoutes, requireAdmin)
+func (s *Server) HandleRoutes(w http.ResponseWriter, r *http.Request) error {
+	return SendJSON(w, s.Routes())
+}
+
+// Get the name of the function fn. Eg. github.com/blacksfk/uf.LogStdout
+func funcName(fn interface{}) string {
+	v := reflect.ValueOf(fn)
+
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return ""
+	}
+
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		return f.Name()
+	}
+
+	return ""
+}
@@ -0,0 +1,97 @@
+package uf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRoutes(t *testing.T) {
+	s := NewServer(&Config{}, middlewareA)
+	s.Get("/car/:id", handleNothing, doNothing).Meta("owner", "pit crew")
+	s.NewGroup("/driver").Meta("team", "Porsche").Get(handleNothing).Post(handleNothing, doNothing)
+
+	routes := s.Routes()
+
+	if len(routes) != 3 {
+		t.Fatalf("expected 3 routes, actual: %+v", routes)
+	}
+
+	car := routes[0]
+
+	if car.Method != http.MethodGet || car.Path != "/car/:id" {
+		t.Errorf("unexpected route: %+v", car)
+	}
+
+	if !strings.HasSuffix(car.Handler, "uf.handleNothing") {
+		t.Errorf("unexpected handler name: %s", car.Handler)
+	}
+
+	if len(car.Middleware) != 2 || !strings.HasSuffix(car.Middleware[0], "uf.middlewareA") ||
+		!strings.HasSuffix(car.Middleware[1], "uf.doNothing") {
+		t.Errorf("unexpected middleware chain: %v", car.Middleware)
+	}
+
+	if car.Meta["owner"] != "pit crew" {
+		t.Errorf("unexpected meta: %v", car.Meta)
+	}
+
+	if post := routes[2]; post.Method != http.MethodPost || post.Meta["team"] != "Porsche" || len(post.Middleware) != 2 {
+		t.Errorf("unexpected group route: %+v", post)
+	}
+}
+
+func TestHandleRoutes(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Get("/debug/routes", s.HandleRoutes)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/debug/routes", nil))
+
+	var routes []RouteInfo
+
+	if e := json.Unmarshal(recorder.Body.Bytes(), &routes); e != nil {
+		t.Fatal(e)
+	}
+
+	if len(routes) != 1 || routes[0].Path != "/debug/routes" {
+		t.Errorf("unexpected route table: %+v", routes)
+	}
+}
+
+func TestURL(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Get("/car/:manufacturer/:model", handleNothing).Name("car")
+	s.Get("/files/*path", handleNothing).Name("files")
+	s.NewGroup("/driver").Name("drivers").Get(handleNothing).Post(handleNothing)
+
+	tests := []struct {
+		name     string
+		params   []Param
+		expected string
+	}{
+		{"car", []Param{{"manufacturer", "BMW"}, {"model", "V12 LM"}}, "/car/BMW/V12%20LM"},
+		{"car", []Param{{"model", "a/b"}, {"manufacturer", "x"}, {"year", "1999"}, {"q", "le mans&spa"}}, "/car/x/a%2Fb?q=le+mans%26spa&year=1999"},
+		{"files", []Param{{"path", "/docs/race report.pdf"}}, "/files/docs/race%20report.pdf"},
+		{"files", []Param{{"path", ""}}, "/files/"},
+		{"drivers", nil, "/driver"},
+	}
+
+	for _, test := range tests {
+		link, e := s.URL(test.name, test.params...)
+
+		if e != nil || link != test.expected {
+			t.Errorf("%s %v: expected %s, actual: %s %v", test.name, test.params, test.expected, link, e)
+		}
+	}
+
+	if _, e := s.URL("car", Param{"manufacturer", "BMW"}); e == nil {
+		t.Error("expected an error for a missing parameter")
+	}
+
+	if _, e := s.URL("track"); e == nil {
+		t.Error("expected an error for an unknown name")
+	}
+}
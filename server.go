@@ -76,8 +76,8 @@ func middlewareA(r *http.Request) error {
 		return uf.Unauthorized("Invalid login")
 	}
 
-	// authenticated
-	*r = *r.WithContext(user.ToContext(r.Context()))
+	// authenticated; see uf.Key
+	userKey.Set(r, user)
 
 	// progress to next handler
 	return nil
@@ -88,6 +88,9 @@ package uf
 import (
 	"github.com/julienschmidt/httprouter"
 	"net/http"
+	"strings"
+	"sync"
+	"time"
 )
 
 // Extension of http.Handler that returns an error to the framework's error handler
@@ -105,11 +108,40 @@ type ErrorLogger func(error)
 // of the request along with an appropriate unit i.e. m, u, or n.
 type AccessLogger func(*http.Request, int64, string)
 
+// Functions implementing this type are supplied the request that exceeded
+// its timeout along with the timeout.
+type TimeoutLogger func(*http.Request, time.Duration)
+
 // Wrapper around vestigo.Router
 type Server struct {
-	Config           *Config
+	Config *Config
+
+	// Middleware called before that of every route. Prefer AddGlobalMiddleware;
+	// assigning directly only takes effect if done before the first request.
 	GlobalMiddleware []Middleware
 	*httprouter.Router
+
+	// every route bound in registration order
+	routes []*Route
+
+	// automatic HEAD routes by path
+	heads map[string]*headHandler
+
+	// queues for requests without a matching route
+	notFound   *Queue
+	notAllowed *Queue
+
+	// global middleware added with a priority
+	ranked []ranked
+
+	// dependency injection container
+	services *Container
+
+	// health check registry
+	health *Health
+
+	// the middleware chains have been resolved before the first request
+	resolved sync.Once
 }
 
 // Server configuration
@@ -119,52 +151,301 @@ type Config struct {
 
 	// Logs requests
 	AccessLogger AccessLogger
+
+	// Limits the number of requests in flight across every route
+	ConcurrencyLimiter *ConcurrencyLimiter
+
+	// Default maximum duration of a request. Requests exceeding it have their
+	// context cancelled and receive a 503 Service Unavailable error. Zero for no limit.
+	Timeout time.Duration
+
+	// Logs requests that exceeded their timeout. ErrorLogger is used if nil.
+	TimeoutLogger TimeoutLogger
+
+	// Compresses responses when the client accepts gzip or deflate. Nil disables compression.
+	Compression *CompressionOptions
+
+	// Handles requests not matching any route. Passes through the global
+	// middleware. Defaults to sending a 404 Not Found HttpError.
+	NotFound Handler
+
+	// Handles requests matching a route for other methods only. Passes through
+	// the global middleware. The Allow header is set beforehand.
+	// Defaults to sending a 405 Method Not Allowed HttpError.
+	MethodNotAllowed Handler
+
+	// Records request metrics for every route. Nil disables metrics.
+	Metrics *Metrics
+
+	// Traces requests. Nil disables tracing.
+	Tracer *Tracer
+
+	// Configures the health checks. Nil for the defaults. See Server.Health.
+	Health *HealthOptions
+
+	// Called when a route has been matched, before any middleware. See RoutePattern.
+	OnRequest func(*http.Request)
+
+	// Called once the middleware has passed, before the handler.
+	OnHandler func(*http.Request)
+
+	// Called once the response has been written.
+	OnResponse func(*http.Request, ResponseInfo)
+
+	// Called with the error returned by a middleware or handler before it is sent.
+	OnError func(*http.Request, error)
+
+	// Called with the value and stack of a panic in a middleware or handler.
+	// Setting it recovers panics, sending a 500 Internal Server Error if
+	// nothing was written. Panics otherwise reach the http.Server.
+	OnPanic func(r *http.Request, recovered interface{}, stack []byte)
+
+	// Generate strong ETags from the body of successful GET and HEAD responses
+	// that do not set one, and answer conditional requests with 304 Not Modified.
+	ETags bool
 }
 
 // Create a new server; optionally specifying global middleware.
 func NewServer(config *Config, m ...Middleware) *Server {
-	return &Server{config, m, httprouter.New()}
+	s := &Server{
+		Config:           config,
+		GlobalMiddleware: chain(m),
+		Router:           httprouter.New(),
+		heads:            make(map[string]*headHandler),
+		services:         NewContainer(),
+		health:           NewHealth(config.Health),
+	}
+
+	if config.Compression != nil {
+		// validate the options before the first request
+		config.Compression.init()
+	}
+
+	s.notFound = newQueue(s.handleNotFound, nil, config)
+	s.notAllowed = newQueue(s.handleMethodNotAllowed, nil, config)
+	s.notFound.services = s.services
+	s.notAllowed.services = s.services
+	s.resolve(s.notFound)
+	s.resolve(s.notAllowed)
+	s.Router.NotFound = s.notFound
+	s.Router.MethodNotAllowed = s.notAllowed
+
+	return s
+}
+
+// Get the dependency injection container whose values are resolved for
+// requests served by the server. See Container.
+func (s *Server) Container() *Container {
+	return s.services
+}
+
+// Get the registry of health checks reported by the endpoints bound with
+// MountHealth. See Health.
+func (s *Server) Health() *Health {
+	return s.health
+}
+
+// Bind GET prefix/healthz to the liveness and GET prefix/readyz to the
+// readiness of the server's health checks. The middleware applies to both.
+func (s *Server) MountHealth(prefix string, m ...Middleware) {
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	s.Get(prefix+"/healthz", s.health.Liveness, m...)
+	s.Get(prefix+"/readyz", s.health.Readiness, m...)
+}
+
+// ServeHTTP implements http.Handler. The middleware chains are resolved
+// again before the first request in case GlobalMiddleware was assigned directly.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.resolved.Do(s.resolveAll)
+	s.Router.ServeHTTP(w, r)
+}
+
+// Set the middleware chain of q: the global middleware followed by the
+// middleware q was bound with, ordered by priority. The chain is a new slice
+// so that it is never shared with another route.
+func (s *Server) resolve(q *Queue) {
+	q.m = sortRanked(rank(0, s.GlobalMiddleware), s.ranked, q.rm)
+}
+
+// Resolve the middleware chain of every queue belonging to the server.
+func (s *Server) resolveAll() {
+	for _, rt := range s.routes {
+		s.resolve(rt.queue)
+	}
+
+	s.resolve(s.notFound)
+	s.resolve(s.notAllowed)
+}
+
+// Concatenate lists of middleware into a new slice.
+func chain(lists ...[]Middleware) []Middleware {
+	n := 0
+
+	for _, list := range lists {
+		n += len(list)
+	}
+
+	m := make([]Middleware, 0, n)
+
+	for _, list := range lists {
+		m = append(m, list...)
+	}
+
+	return m
 }
 
-// Bind endpoint to the specified method, append the supplied middleware (if any)
-// to the global middleware and create the middleware queue.
-func (s *Server) bind(method, endpoint string, h Handler, m []Middleware) {
-	m = append(s.GlobalMiddleware, m...)
+// Send Config.NotFound or the default 404 Not Found error.
+func (s *Server) handleNotFound(w http.ResponseWriter, r *http.Request) error {
+	if s.Config.NotFound != nil {
+		return s.Config.NotFound(w, r)
+	}
+
+	return NotFound("No route matches " + r.URL.Path)
+}
+
+// Send Config.MethodNotAllowed or the default 405 Method Not Allowed error.
+func (s *Server) handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) error {
+	if s.Config.MethodNotAllowed != nil {
+		return s.Config.MethodNotAllowed(w, r)
+	}
+
+	allow := w.Header().Get("Allow")
+
+	return MethodNotAllowed(r.Method+" is not allowed for "+r.URL.Path+". Allow: "+allow).
+		WithHeader("Allow", allow)
+}
+
+// Bind endpoint to the specified method with the supplied middleware (if any),
+// create the middleware queue and record the route. The global middleware
+// precedes m regardless of when it is added.
+func (s *Server) bind(method, endpoint string, h Handler, m []Middleware) *Route {
+	q := newQueue(h, chain(m), s.Config)
+	s.resolve(q)
+	rt := &Route{method: method, path: endpoint, handler: h, queue: q, server: s}
+	q.route = rt
+	q.services = s.services
+
+	s.register(method, endpoint, q)
+	s.routes = append(s.routes, rt)
 
-	s.Handler(method, endpoint, newQueue(h, m, s.Config))
+	return rt
+}
+
+// Register q with the router. GET routes also answer HEAD requests unless
+// a HEAD route is bound to the same path, before or after.
+func (s *Server) register(method, endpoint string, q *Queue) {
+	switch method {
+	case http.MethodGet:
+		s.Router.Handler(method, endpoint, q)
+
+		if s.route(http.MethodHead, endpoint) == nil {
+			hh := &headHandler{get: q}
+			s.heads[endpoint] = hh
+			s.Router.Handler(http.MethodHead, endpoint, hh)
+		}
+	case http.MethodHead:
+		if hh, ok := s.heads[endpoint]; ok {
+			// the router does not allow re-binding so replace the automatic route
+			hh.head = q
+			delete(s.heads, endpoint)
+		} else {
+			s.Router.Handler(method, endpoint, q)
+		}
+	default:
+		s.Router.Handler(method, endpoint, q)
+	}
+}
+
+// Bind endpoint to support requests with an arbitrary method. Eg. PROPFIND or QUERY.
+func (s *Server) Handle(method, endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(method, endpoint, c, m)
 }
 
 // Bind endpoint to support GET requests.
-func (s *Server) Get(endpoint string, c Handler, m ...Middleware) {
-	s.bind(http.MethodGet, endpoint, c, m)
+func (s *Server) Get(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodGet, endpoint, c, m)
+}
+
+// Bind endpoint to support HEAD requests. GET routes answer HEAD requests
+// automatically, discarding the body, so this is only needed to handle them differently.
+func (s *Server) Head(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodHead, endpoint, c, m)
+}
+
+// Bind endpoint to support OPTIONS requests, replacing the router's
+// automatic response (see GlobalOPTIONS) for endpoint.
+func (s *Server) Options(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodOptions, endpoint, c, m)
 }
 
 // Bind endpoint to support POST requests.
-func (s *Server) Post(endpoint string, c Handler, m ...Middleware) {
-	s.bind(http.MethodPost, endpoint, c, m)
+func (s *Server) Post(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodPost, endpoint, c, m)
 }
 
 // Bind endpoint to support PUT requests.
-func (s *Server) Put(endpoint string, c Handler, m ...Middleware) {
-	s.bind(http.MethodPut, endpoint, c, m)
+func (s *Server) Put(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodPut, endpoint, c, m)
 }
 
 // Bind endpoint to support PATCH requests.
-func (s *Server) Patch(endpoint string, c Handler, m ...Middleware) {
-	s.bind(http.MethodPatch, endpoint, c, m)
+func (s *Server) Patch(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodPatch, endpoint, c, m)
 }
 
 // Bind endpoint to support DELETE requests.
-func (s *Server) Delete(endpoint string, c Handler, m ...Middleware) {
-	s.bind(http.MethodDelete, endpoint, c, m)
+func (s *Server) Delete(endpoint string, c Handler, m ...Middleware) *Route {
+	return s.bind(http.MethodDelete, endpoint, c, m)
+}
+
+// Methods bound by Any. HEAD requests are answered by the GET route.
+var anyMethods = []string{
+	http.MethodGet,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+	http.MethodConnect,
+	http.MethodTrace,
+}
+
+// Bind endpoint to support requests with any of the standard methods. The
+// routes are returned in the order GET, POST, PUT, PATCH, DELETE, OPTIONS,
+// CONNECT, TRACE.
+func (s *Server) Any(endpoint string, c Handler, m ...Middleware) []*Route {
+	routes := make([]*Route, len(anyMethods))
+
+	for i, method := range anyMethods {
+		routes[i] = s.bind(method, endpoint, c, m)
+	}
+
+	return routes
 }
 
-// Append (or set if not existing) middleware to apply to all routes.
+// Append (or set if not existing) middleware to apply to all routes, including
+// those already bound. The global middleware is called in the order it was
+// added, before route and group middleware. Not safe to call while serving requests.
 func (s *Server) AddGlobalMiddleware(m ...Middleware) {
-	s.GlobalMiddleware = append(s.GlobalMiddleware, m...)
+	s.GlobalMiddleware = chain(s.GlobalMiddleware, m)
+	s.resolveAll()
+}
+
+// Add global middleware with an explicit priority. Middleware runs in
+// ascending order of priority, which is zero unless specified. Middleware of
+// equal priority runs global first, then group, then route, each in the order
+// added. Eg. to run a recovery middleware before anything else:
+//
+//	server.AddGlobalMiddlewareAt(-100, recoverPanics)
+//
+// Not safe to call while serving requests.
+func (s *Server) AddGlobalMiddlewareAt(priority int, m ...Middleware) {
+	s.ranked = append(s.ranked[:len(s.ranked):len(s.ranked)], rank(priority, m)...)
+	s.resolveAll()
 }
 
 // Create a group to bind multiple HTTP verbs to a single endpoint concisely
 func (s *Server) NewGroup(endpoint string, routeWide ...Middleware) *Group {
-	return &Group{endpoint, routeWide, s}
+	return &Group{endpoint: endpoint, middleware: chain(routeWide), server: s}
 }
@@ -2,7 +2,10 @@ package uf
 
 import (
 	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 )
 
 var expected string = "AB"
@@ -36,3 +39,234 @@ func TestGlobalMiddleware(t *testing.T) {
 func middlewareA(r *http.Request) error {
 	return nil
 }
+
+func TestRouteTimeout(t *testing.T) {
+	s := NewServer(&Config{Timeout: time.Minute})
+	rt := s.Get("/", handleNothing).Timeout(-1)
+
+	if rt.queue.timeout != -1 {
+		t.Errorf("route timeout: expected: -1, actual: %v", rt.queue.timeout)
+	}
+
+	if rt = s.Post("/", handleNothing); rt.queue.timeout != time.Minute {
+		t.Errorf("route timeout: expected: %v, actual: %v", time.Minute, rt.queue.timeout)
+	}
+}
+
+func TestAutomaticHead(t *testing.T) {
+	s := NewServer(&Config{})
+	body := strings.Repeat("Le Mans ", 1000)
+	s.Get("/race", func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Winner", "Porsche")
+
+		return SendJSON(w, body)
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, "/race", nil))
+
+	if recorder.Code != http.StatusOK || recorder.Body.Len() != 0 {
+		t.Errorf("unexpected response: %d %d bytes", recorder.Code, recorder.Body.Len())
+	}
+
+	if recorder.Header().Get("X-Winner") != "Porsche" {
+		t.Errorf("headers were not preserved: %v", recorder.Header())
+	}
+
+	// the encoded string is quoted and followed by a newline
+	if cl := recorder.Header().Get("Content-Length"); cl != "8003" {
+		t.Errorf("Content-Length: expected: 8003, actual: %s", cl)
+	}
+
+	// an explicit HEAD route replaces the automatic one
+	s.Head("/race", func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusNoContent)
+
+		return nil
+	})
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodHead, "/race", nil))
+
+	if recorder.Code != http.StatusNoContent {
+		t.Errorf("explicit HEAD route was not used: %d", recorder.Code)
+	}
+
+	// and prevents one being created
+	s.Head("/pit", handleNothing)
+	s.Get("/pit", handleNothing)
+}
+
+func TestHandle(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Handle("PROPFIND", "/dav", func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusMultiStatus)
+
+		return nil
+	})
+	s.Options("/dav", handleNothing)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest("PROPFIND", "/dav", nil))
+
+	if recorder.Code != http.StatusMultiStatus {
+		t.Errorf("PROPFIND: expected: %d, actual: %d", http.StatusMultiStatus, recorder.Code)
+	}
+
+	if h, _, _ := s.Lookup(http.MethodOptions, "/dav"); h == nil {
+		t.Error("OPTIONS route not bound")
+	}
+}
+
+func TestAny(t *testing.T) {
+	s := NewServer(&Config{})
+	routes := s.Any("/echo", func(w http.ResponseWriter, r *http.Request) error {
+		w.Header().Set("X-Method", r.Method)
+
+		return nil
+	})
+
+	if len(routes) != len(anyMethods) {
+		t.Errorf("expected %d routes, actual: %d", len(anyMethods), len(routes))
+	}
+
+	for _, method := range append(anyMethods, http.MethodHead) {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(method, "/echo", nil))
+
+		if recorder.Header().Get("X-Method") != method {
+			t.Errorf("%s: not handled: %d", method, recorder.Code)
+		}
+	}
+}
+
+func TestFallback(t *testing.T) {
+	var logged []string
+	s := NewServer(&Config{
+		AccessLogger: func(r *http.Request, d int64, unit string) {
+			logged = append(logged, r.Method+" "+r.URL.Path)
+		},
+	})
+
+	// global middleware added after creating the server still applies
+	s.AddGlobalMiddleware(func(r *http.Request) error {
+		ResponseHeader(r).Set("Access-Control-Allow-Origin", "*")
+
+		return nil
+	})
+
+	s.Get("/car", handleNothing)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/track", nil))
+
+	if recorder.Code != http.StatusNotFound || recorder.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("unexpected 404 response: %d %v", recorder.Code, recorder.Header())
+	}
+
+	if recorder.Header().Get("Access-Control-Allow-Origin") != "*" {
+		t.Error("global middleware did not run for 404")
+	}
+
+	recorder = httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/car", nil))
+
+	if recorder.Code != http.StatusMethodNotAllowed || !strings.Contains(recorder.Header().Get("Allow"), http.MethodGet) {
+		t.Errorf("unexpected 405 response: %d %v", recorder.Code, recorder.Header())
+	}
+
+	if !strings.Contains(recorder.Body.String(), `"code":405`) {
+		t.Errorf("unexpected 405 body: %s", recorder.Body)
+	}
+
+	if len(logged) != 2 || logged[0] != "GET /track" || logged[1] != "POST /car" {
+		t.Errorf("unmatched requests were not logged: %v", logged)
+	}
+}
+
+func TestMethodNotAllowedTimeout(t *testing.T) {
+	s := NewServer(&Config{Timeout: time.Second})
+	s.Get("/car", handleNothing)
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/car", nil))
+
+	if allow := recorder.Header().Get("Allow"); recorder.Code != http.StatusMethodNotAllowed || !strings.Contains(allow, http.MethodGet) {
+		t.Errorf("unexpected 405 response: %d %v", recorder.Code, recorder.Header())
+	}
+
+	if !strings.Contains(recorder.Body.String(), "Allow: GET") {
+		t.Errorf("unexpected 405 body: %s", recorder.Body)
+	}
+}
+
+func TestFallbackOverride(t *testing.T) {
+	s := NewServer(&Config{
+		NotFound: func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusTeapot)
+
+			return nil
+		},
+	})
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if recorder.Code != http.StatusTeapot {
+		t.Errorf("Config.NotFound was not used: %d", recorder.Code)
+	}
+}
+
+// Middleware recording its name into the response header "X-Order".
+func orderMiddleware(name string) Middleware {
+	return func(r *http.Request) error {
+		ResponseHeader(r).Add("X-Order", name)
+
+		return nil
+	}
+}
+
+// Request path with method and return the order in which middleware was called.
+func order(s *Server, method, path string) string {
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+
+	return strings.Join(recorder.Header().Values("X-Order"), ",")
+}
+
+func TestMiddlewareOrder(t *testing.T) {
+	s := NewServer(&Config{}, orderMiddleware("g1"))
+	s.Get("/car", handleNothing, orderMiddleware("r"))
+
+	// a group whose middleware slice has spare capacity
+	group := s.NewGroup("/driver", make([]Middleware, 0, 8)...).Middleware(orderMiddleware("a"))
+	group.Get(handleNothing, orderMiddleware("get")).Post(handleNothing, orderMiddleware("post"))
+
+	// added after the routes were bound
+	s.AddGlobalMiddleware(orderMiddleware("g2"))
+
+	tests := []struct {
+		method, path, expected string
+	}{
+		{http.MethodGet, "/car", "g1,g2,r"},
+		{http.MethodGet, "/driver", "g1,g2,a,get"},
+		{http.MethodPost, "/driver", "g1,g2,a,post"},
+		{http.MethodGet, "/track", "g1,g2"},
+	}
+
+	for _, test := range tests {
+		if actual := order(s, test.method, test.path); actual != test.expected {
+			t.Errorf("%s %s: expected: %s, actual: %s", test.method, test.path, test.expected, actual)
+		}
+	}
+}
+
+func TestGlobalMiddlewareAssigned(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Get("/car", handleNothing, orderMiddleware("r"))
+	s.GlobalMiddleware = []Middleware{orderMiddleware("g")}
+
+	if actual := order(s, http.MethodGet, "/car"); actual != "g,r" {
+		t.Errorf("expected: g,r, actual: %s", actual)
+	}
+}
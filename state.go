@@ -0,0 +1,113 @@
+package uf
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// Supplied to functions registered with Defer when a middleware or handler
+// panicked. The panic continues once they have been called.
+var ErrPanicked = errors.New("uf: panic while serving request")
+
+// Per-request state shared between a Queue and the middleware and
+// handlers it runs.
+type state struct {
+	header   http.Header
+	deferred []func(error)
+	wrappers []func(Handler) Handler
+	route    *Route
+	el       ErrorLogger
+
+	// values set with a Key
+	mu     sync.Mutex
+	values map[interface{}]interface{}
+
+	// replacement request returned by a RequestMiddleware
+	next *http.Request
+
+	// the server's container and the values it built for the request
+	services  *Container
+	scoped    map[*provider]interface{}
+	disposing []interface{}
+}
+
+type stateKey struct{}
+
+// Attach s to a copy of r.
+func withState(r *http.Request, s *state) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), stateKey{}, s))
+}
+
+// Get the state attached to r. Returns nil if r is not being served by a Queue.
+func stateFrom(r *http.Request) *state {
+	s, _ := r.Context().Value(stateKey{}).(*state)
+
+	return s
+}
+
+// Call the deferred functions in reverse order of registration.
+func (s *state) done(e error) {
+	for i := len(s.deferred) - 1; i >= 0; i-- {
+		s.deferred[i](e)
+	}
+}
+
+// Supply e to the error logger of the Queue, if any.
+func (s *state) logError(e error) {
+	if s.el != nil {
+		s.el(e)
+	}
+}
+
+// Get the header map of the response being served for r. Allows middleware
+// to set response headers for successful requests. If r is not being served
+// by a Queue an empty header is returned and any values set are discarded.
+func ResponseHeader(r *http.Request) http.Header {
+	if s := stateFrom(r); s != nil {
+		return s.header
+	}
+
+	return http.Header{}
+}
+
+// Register wrap to wrap the handler serving r once the middleware have run.
+// Wrappers registered first are outermost. Returns false without registering
+// wrap if r is not being served by a Queue.
+func wrapHandler(r *http.Request, wrap func(Handler) Handler) bool {
+	s := stateFrom(r)
+
+	if s == nil {
+		return false
+	}
+
+	s.wrappers = append(s.wrappers, wrap)
+
+	return true
+}
+
+// Wrap c with the wrappers registered for the request.
+func (s *state) wrap(c Handler) Handler {
+	for i := len(s.wrappers) - 1; i >= 0; i-- {
+		c = s.wrappers[i](c)
+	}
+
+	return c
+}
+
+// Register fn to be called once the Queue serving r has finished, with the
+// error returned by the middleware or handler (nil on success, ErrPanicked
+// if one panicked). Functions are called in reverse order of registration.
+// Returns false without registering fn if r is not being served by a Queue.
+func Defer(r *http.Request, fn func(error)) bool {
+	s := stateFrom(r)
+
+	if s == nil {
+		return false
+	}
+
+	s.deferred = append(s.deferred, fn)
+
+	return true
+}
@@ -0,0 +1,232 @@
+package uf
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// Static file serving configuration.
+type StaticOptions struct {
+	// File served for directory requests. Defaults to index.html.
+	Index string
+
+	// Serve the root index file for missing paths without a file extension
+	// so that a single page application can handle its own routing.
+	SPA bool
+
+	// Serve the ".gz" sibling of a file, if one exists, to clients accepting gzip.
+	Precompressed bool
+
+	// Value of the Cache-Control header sent with files. Eg. "public, max-age=86400".
+	CacheControl string
+
+	// List the contents of directories without an index file as JSON.
+	// Directories without an index file are not found otherwise.
+	Browse bool
+}
+
+// An entry in a directory listing.
+type DirEntry struct {
+	Name     string    `json:"name"`
+	Dir      bool      `json:"dir"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// Serve the files in fsys (eg. an embed.FS) from prefix for GET and HEAD
+// requests, passing through the global middleware and m. Range and
+// conditional requests are supported; missing files are reported as
+// 404 Not Found errors.
+//
+//	//go:embed dist
+//	var dist embed.FS
+//
+//	sub, _ := fs.Sub(dist, "dist")
+//	server.Static("/app", sub, &uf.StaticOptions{SPA: true})
+//
+// httprouter does not allow a catch-all route at the root alongside other
+// routes, so files served from "/" are served for GET and HEAD requests not
+// matching any route instead, in place of Config.NotFound (which still
+// handles other methods). m then applies to every request not matching a route.
+func (s *Server) Static(prefix string, fsys fs.FS, opts *StaticOptions, m ...Middleware) {
+	if opts == nil {
+		opts = &StaticOptions{}
+	}
+
+	prefix = strings.TrimSuffix(prefix, "/")
+
+	if prefix == "" {
+		st := &static{fsys, *opts, true}
+		q := newQueue(st.fallback(s.notFound.c), chain(m), s.Config)
+		q.services = s.services
+		s.resolve(q)
+		s.notFound = q
+		s.Router.NotFound = q
+
+		return
+	}
+
+	// HEAD requests are answered by the GET route
+	s.Get(prefix+"/*filepath", (&static{fsys, *opts, false}).serve, m...)
+}
+
+type static struct {
+	fsys fs.FS
+	opts StaticOptions
+
+	// served from the root for requests not matching a route
+	root bool
+}
+
+// Serve GET and HEAD requests, passing others to next.
+func (st *static) fallback(next Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			return next(w, r)
+		}
+
+		return st.serve(w, r)
+	}
+}
+
+func (st *static) index() string {
+	if st.opts.Index != "" {
+		return st.opts.Index
+	}
+
+	return "index.html"
+}
+
+func (st *static) serve(w http.ResponseWriter, r *http.Request) error {
+	name := GetParam(r, "filepath")
+
+	if st.root {
+		name = r.URL.Path
+	}
+
+	name = path.Clean("/" + name)[1:]
+
+	if name == "" {
+		name = "."
+	}
+
+	info, e := fs.Stat(st.fsys, name)
+
+	if errors.Is(e, fs.ErrNotExist) && st.opts.SPA && path.Ext(name) == "" {
+		name = st.index()
+		info, e = fs.Stat(st.fsys, name)
+	}
+
+	if e != nil {
+		return st.notFound(name, e)
+	}
+
+	if info.IsDir() {
+		index := path.Join(name, st.index())
+
+		if info, e = fs.Stat(st.fsys, index); e == nil && !info.IsDir() {
+			name = index
+		} else if st.opts.Browse {
+			return st.list(w, name)
+		} else {
+			return NotFound(name + " is a directory")
+		}
+	}
+
+	return st.serveFile(w, r, name, info)
+}
+
+// Send a file, or its precompressed sibling if the client accepts gzip.
+func (st *static) serveFile(w http.ResponseWriter, r *http.Request, name string, info fs.FileInfo) error {
+	h := w.Header()
+	served := name
+
+	if st.opts.CacheControl != "" {
+		h.Set("Cache-Control", st.opts.CacheControl)
+	}
+
+	if st.opts.Precompressed {
+		h.Add("Vary", "Accept-Encoding")
+
+		if negotiateEncoding(r.Header.Get("Accept-Encoding")) == "gzip" {
+			if gz, e := fs.Stat(st.fsys, name+".gz"); e == nil && !gz.IsDir() {
+				served = name + ".gz"
+				info = gz
+
+				// describe the original file rather than the archive
+				if ct := mime.TypeByExtension(path.Ext(name)); ct != "" {
+					h.Set("Content-Type", ct)
+				}
+
+				h.Set("Content-Encoding", "gzip")
+			}
+		}
+	}
+
+	f, e := st.fsys.Open(served)
+
+	if e != nil {
+		return st.notFound(name, e)
+	}
+
+	defer f.Close()
+	content, ok := f.(io.ReadSeeker)
+
+	if !ok {
+		// not all file systems support seeking which ranges require
+		b, e := io.ReadAll(f)
+
+		if e != nil {
+			return e
+		}
+
+		content = bytes.NewReader(b)
+	}
+
+	http.ServeContent(w, r, name, info.ModTime(), content)
+
+	return nil
+}
+
+// Send the entries of the directory name as JSON.
+func (st *static) list(w http.ResponseWriter, name string) error {
+	entries, e := fs.ReadDir(st.fsys, name)
+
+	if e != nil {
+		return st.notFound(name, e)
+	}
+
+	list := make([]DirEntry, 0, len(entries))
+
+	for _, entry := range entries {
+		info, e := entry.Info()
+
+		if e != nil {
+			continue
+		}
+
+		list = append(list, DirEntry{entry.Name(), entry.IsDir(), info.Size(), info.ModTime()})
+	}
+
+	return SendJSON(w, list)
+}
+
+// Convert file system errors into HttpErrors.
+func (st *static) notFound(name string, e error) error {
+	if errors.Is(e, fs.ErrNotExist) || errors.Is(e, fs.ErrInvalid) {
+		return NotFound(name + " does not exist")
+	}
+
+	if errors.Is(e, fs.ErrPermission) {
+		return Forbidden(name + " is not accessible")
+	}
+
+	return e
+}
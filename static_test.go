@@ -0,0 +1,163 @@
+package uf
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func newStaticServer(opts *StaticOptions) *Server {
+	fsys := fstest.MapFS{
+		"index.html":         {Data: []byte("<h1>Le Mans</h1>")},
+		"css/app.css":        {Data: []byte("body { color: red }")},
+		"js/app.js":          {Data: []byte("console.log('uncompressed')")},
+		"js/app.js.gz":       {Data: []byte("compressed")},
+		"docs/1998/gt1.txt":  {Data: []byte("Porsche")},
+		"docs/1999/lmgtp.md": {Data: []byte("BMW")},
+	}
+
+	s := NewServer(&Config{})
+	s.Static("/app", fsys, opts)
+
+	return s
+}
+
+func staticGet(s *Server, path string, kv ...string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+
+	for i := 0; i < len(kv); i += 2 {
+		r.Header.Set(kv[i], kv[i+1])
+	}
+
+	s.ServeHTTP(recorder, r)
+
+	return recorder
+}
+
+func TestStatic(t *testing.T) {
+	s := newStaticServer(&StaticOptions{CacheControl: "public, max-age=60"})
+
+	res := staticGet(s, "/app/css/app.css")
+
+	if res.Code != http.StatusOK || res.Body.String() != "body { color: red }" {
+		t.Fatalf("unexpected response: %d %s", res.Code, res.Body)
+	}
+
+	if v := res.Header().Get("Content-Type"); v != "text/css; charset=utf-8" {
+		t.Errorf("Content-Type: %q", v)
+	}
+
+	if v := res.Header().Get("Cache-Control"); v != "public, max-age=60" {
+		t.Errorf("Cache-Control: %q", v)
+	}
+
+	// the index file is served for directories
+	if res = staticGet(s, "/app/"); res.Body.String() != "<h1>Le Mans</h1>" {
+		t.Errorf("index was not served: %d %s", res.Code, res.Body)
+	}
+
+	// ranges are supported
+	res = staticGet(s, "/app/css/app.css", "Range", "bytes=0-3")
+
+	if res.Code != http.StatusPartialContent || res.Body.String() != "body" {
+		t.Errorf("range was not served: %d %s", res.Code, res.Body)
+	}
+
+	// misses are uf errors
+	res = staticGet(s, "/app/missing.css")
+
+	if res.Code != http.StatusNotFound || res.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("expected a JSON 404 response: %d %v", res.Code, res.Header())
+	}
+
+	// directories without an index are hidden
+	if res = staticGet(s, "/app/docs"); res.Code != http.StatusNotFound {
+		t.Errorf("expected: 404, actual: %d", res.Code)
+	}
+
+	// SPA fallback is disabled
+	if res = staticGet(s, "/app/garage/1"); res.Code != http.StatusNotFound {
+		t.Errorf("expected: 404, actual: %d", res.Code)
+	}
+
+	if res = staticGet(s, "/app/../static.go"); res.Code == http.StatusOK {
+		t.Errorf("escaped the file system: %s", res.Body)
+	}
+}
+
+func TestStaticSPA(t *testing.T) {
+	s := newStaticServer(&StaticOptions{SPA: true})
+
+	if res := staticGet(s, "/app/garage/1"); res.Body.String() != "<h1>Le Mans</h1>" {
+		t.Errorf("index was not served: %d %s", res.Code, res.Body)
+	}
+
+	// missing assets are still not found
+	if res := staticGet(s, "/app/missing.css"); res.Code != http.StatusNotFound {
+		t.Errorf("expected: 404, actual: %d", res.Code)
+	}
+}
+
+func TestStaticRoot(t *testing.T) {
+	s := NewServer(&Config{})
+	s.Static("/", fstest.MapFS{"index.html": {Data: []byte("<h1>Le Mans</h1>")}}, &StaticOptions{SPA: true})
+
+	// bound after the files without conflicting
+	s.Get("/api/car", func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, "GT1")
+	})
+
+	for _, path := range []string{"/", "/garage/1"} {
+		if res := staticGet(s, path); res.Body.String() != "<h1>Le Mans</h1>" {
+			t.Errorf("%s: index was not served: %d %s", path, res.Code, res.Body)
+		}
+	}
+
+	if res := staticGet(s, "/api/car"); res.Body.String() != "\"GT1\"\n" {
+		t.Errorf("route was not served: %s", res.Body)
+	}
+
+	recorder := httptest.NewRecorder()
+	s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/garage/1", nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("expected: 404, actual: %d", recorder.Code)
+	}
+}
+
+func TestStaticPrecompressed(t *testing.T) {
+	s := newStaticServer(&StaticOptions{Precompressed: true})
+
+	res := staticGet(s, "/app/js/app.js", "Accept-Encoding", "gzip")
+
+	if res.Body.String() != "compressed" || res.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("precompressed file was not served: %v %s", res.Header(), res.Body)
+	}
+
+	if v := res.Header().Get("Content-Type"); v != "text/javascript; charset=utf-8" {
+		t.Errorf("Content-Type: %q", v)
+	}
+
+	if res = staticGet(s, "/app/js/app.js"); res.Body.String() != "console.log('uncompressed')" {
+		t.Errorf("uncompressed file was not served: %s", res.Body)
+	}
+}
+
+func TestStaticBrowse(t *testing.T) {
+	s := newStaticServer(&StaticOptions{Browse: true})
+
+	res := staticGet(s, "/app/docs")
+
+	var entries []DirEntry
+
+	if e := json.Unmarshal(res.Body.Bytes(), &entries); e != nil {
+		t.Fatal(e)
+	}
+
+	if len(entries) != 2 || entries[0].Name != "1998" || !entries[0].Dir {
+		t.Errorf("unexpected listing: %+v", entries)
+	}
+}
@@ -0,0 +1,424 @@
+package uf
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Identifies a trace. See https://www.w3.org/TR/trace-context/.
+type TraceID [16]byte
+
+// Identifies a span within a trace.
+type SpanID [8]byte
+
+func (id TraceID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Whether id is not all zeroes.
+func (id TraceID) IsValid() bool {
+	return id != TraceID{}
+}
+
+func (id SpanID) String() string {
+	return hex.EncodeToString(id[:])
+}
+
+// Whether id is not all zeroes.
+func (id SpanID) IsValid() bool {
+	return id != SpanID{}
+}
+
+// The identity of a span propagated between services in the traceparent
+// and tracestate headers.
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+
+	// Vendor specific data passed on unchanged.
+	TraceState string
+}
+
+// Parse the traceparent and tracestate headers of h. Returns false if
+// traceparent is missing or invalid.
+func ParseTraceContext(h http.Header) (SpanContext, bool) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(h.Get("traceparent")), "-")
+
+	// later versions may append fields
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return sc, false
+	}
+
+	version, e1 := decodeHex(parts[0], 1)
+	traceID, e2 := decodeHex(parts[1], len(sc.TraceID))
+	spanID, e3 := decodeHex(parts[2], len(sc.SpanID))
+	flags, e4 := decodeHex(parts[3], 1)
+
+	if e1 != nil || e2 != nil || e3 != nil || e4 != nil || version == nil {
+		return sc, false
+	}
+
+	copy(sc.TraceID[:], traceID)
+	copy(sc.SpanID[:], spanID)
+	sc.Sampled = flags[0]&1 == 1
+
+	if !sc.TraceID.IsValid() || !sc.SpanID.IsValid() {
+		return SpanContext{}, false
+	}
+
+	if ts := strings.Join(h.Values("tracestate"), ","); len(ts) <= 512 {
+		sc.TraceState = ts
+	}
+
+	return sc, true
+}
+
+// Decode lowercase hex of exactly n bytes.
+func decodeHex(s string, n int) ([]byte, error) {
+	if len(s) != n*2 || strings.ToLower(s) != s {
+		return nil, errors.New("invalid hex")
+	}
+
+	return hex.DecodeString(s)
+}
+
+// Set the traceparent and tracestate headers of h to propagate sc.
+func (sc SpanContext) Inject(h http.Header) {
+	flags := "00"
+
+	if sc.Sampled {
+		flags = "01"
+	}
+
+	h.Set("traceparent", "00-"+sc.TraceID.String()+"-"+sc.SpanID.String()+"-"+flags)
+
+	if sc.TraceState != "" {
+		h.Set("tracestate", sc.TraceState)
+	} else {
+		h.Del("tracestate")
+	}
+}
+
+// The role of a span.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+// The outcome of a span.
+type SpanStatus int
+
+const (
+	SpanStatusUnset SpanStatus = iota
+	SpanStatusOK
+	SpanStatusError
+)
+
+// A timed operation within a trace. The exported fields must not be changed
+// once the span has ended.
+type Span struct {
+	Name          string
+	Service       string
+	Context       SpanContext
+	Parent        SpanID
+	Kind          SpanKind
+	StartTime     time.Time
+	EndTime       time.Time
+	Attributes    map[string]interface{}
+	Status        SpanStatus
+	StatusMessage string
+
+	mu    sync.Mutex
+	trace *trace
+	ended bool
+}
+
+// The spans of a trace belonging to one request.
+type trace struct {
+	tracer *Tracer
+	mu     sync.Mutex
+	spans  []*Span
+}
+
+// Set an attribute of the span. Values should be strings, bools, integers or floats.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Attributes == nil {
+		s.Attributes = make(map[string]interface{})
+	}
+
+	s.Attributes[key] = value
+}
+
+// Mark the span as failed. The message of an HttpError is recorded along
+// with its code; other errors are only recorded as failures.
+func (s *Span) SetError(e error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.Status = SpanStatusError
+
+	if he, ok := AsHttpError(e); ok {
+		s.StatusMessage = he.Message
+	}
+}
+
+// End the span. Only the first call has an effect.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+
+	if s.ended {
+		s.mu.Unlock()
+
+		return
+	}
+
+	s.ended = true
+	s.EndTime = time.Now()
+	s.mu.Unlock()
+
+	if s.Context.Sampled {
+		s.trace.mu.Lock()
+		s.trace.spans = append(s.trace.spans, s)
+		s.trace.mu.Unlock()
+	}
+}
+
+type spanKey struct{}
+
+// Get the current span of ctx. Returns nil if there is none.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+
+	return s
+}
+
+// Start a span as a child of the current span of ctx, returning a context
+// in which the new span is current. Returns ctx and a nil span if ctx has no
+// current span, ie. the request is not being traced; the methods of a nil
+// span do nothing.
+//
+//	ctx, span := uf.StartSpan(r.Context(), "query books")
+//	defer span.End()
+func StartSpan(ctx context.Context, name string) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+
+	if parent == nil {
+		return ctx, nil
+	}
+
+	span := parent.child(name, SpanKindInternal)
+
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// Set the traceparent and tracestate headers of h to propagate the current
+// span of ctx to an outgoing request. Does nothing if there is none.
+func InjectTraceContext(ctx context.Context, h http.Header) {
+	if s := SpanFromContext(ctx); s != nil {
+		s.Context.Inject(h)
+	}
+}
+
+func (s *Span) child(name string, kind SpanKind) *Span {
+	return &Span{
+		Name:      name,
+		Service:   s.Service,
+		Context:   SpanContext{s.Context.TraceID, newSpanID(), s.Context.Sampled, s.Context.TraceState},
+		Parent:    s.Context.SpanID,
+		Kind:      kind,
+		StartTime: time.Now(),
+		trace:     s.trace,
+	}
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+
+	return id
+}
+
+func newTraceID() TraceID {
+	var id TraceID
+
+	for !id.IsValid() {
+		rand.Read(id[:])
+	}
+
+	return id
+}
+
+// Receives the spans of traced requests. Implementations must not modify
+// the spans and should not block; see OTLPExporter.
+type Exporter interface {
+	Export(spans []*Span) error
+}
+
+// Creates a server span for each request served by a server configured
+// with it (see Config.Tracer), continuing the trace of the traceparent
+// header if present, with child spans for each middleware and the handler.
+// The spans of a request are exported together once its server span ends.
+type Tracer struct {
+	service  string
+	exporter Exporter
+}
+
+// Create a tracer for the service named service.
+func NewTracer(service string, exporter Exporter) *Tracer {
+	return &Tracer{service: service, exporter: exporter}
+}
+
+// Start the server span of r, returning a copy of r in whose context it
+// is current. Requests without a valid traceparent start a new sampled trace.
+func (t *Tracer) startServer(r *http.Request, route string) (*http.Request, *Span) {
+	sc, ok := ParseTraceContext(r.Header)
+	var parent SpanID
+
+	if ok {
+		parent = sc.SpanID
+	} else {
+		sc = SpanContext{TraceID: newTraceID(), Sampled: true}
+	}
+
+	sc.SpanID = newSpanID()
+	name := r.Method
+
+	if route != "" {
+		name += " " + route
+	}
+
+	span := &Span{
+		Name:      name,
+		Service:   t.service,
+		Context:   sc,
+		Parent:    parent,
+		Kind:      SpanKindServer,
+		StartTime: time.Now(),
+		Attributes: map[string]interface{}{
+			"http.request.method": r.Method,
+			"url.path":            r.URL.Path,
+		},
+		trace: &trace{tracer: t},
+	}
+
+	if route != "" {
+		span.Attributes["http.route"] = route
+	}
+
+	return r.WithContext(context.WithValue(r.Context(), spanKey{}, span)), span
+}
+
+// End the server span with the status code sent and export the spans of
+// its request.
+func (s *Span) finish(status int) error {
+	s.SetAttribute("http.response.status_code", status)
+
+	s.mu.Lock()
+
+	// server errors are failures; client errors are the client's
+	if status >= 500 {
+		s.Status = SpanStatusError
+	}
+
+	s.mu.Unlock()
+	s.End()
+
+	s.trace.mu.Lock()
+	spans := s.trace.spans
+	s.trace.spans = nil
+	s.trace.mu.Unlock()
+
+	if len(spans) == 0 || s.trace.tracer.exporter == nil {
+		return nil
+	}
+
+	return s.trace.tracer.exporter.Export(spans)
+}
+
+// Start a child span of the server span of r for the function fn. Returns nil if r is not traced.
+func startFuncSpan(r *http.Request, fn interface{}) *Span {
+	parent := SpanFromContext(r.Context())
+
+	if parent == nil || parent.Kind != SpanKindServer {
+		return nil
+	}
+
+	name := funcName(fn)
+
+	if i := strings.LastIndexByte(name, '/'); i >= 0 {
+		// github.com/blacksfk/uf.LogStdout becomes uf.LogStdout
+		name = name[i+1:]
+	}
+
+	return parent.child(name, SpanKindInternal)
+}
+
+// End a span started with startFuncSpan, recording e.
+func endFuncSpan(s *Span, e error) {
+	if e != nil {
+		s.SetError(e)
+	}
+
+	s.End()
+}
+
+// Collects exported spans in memory. Intended for tests.
+type MemoryExporter struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+// Export implements Exporter.
+func (me *MemoryExporter) Export(spans []*Span) error {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.spans = append(me.spans, spans...)
+
+	return nil
+}
+
+// Get the spans exported so far in the order they ended.
+func (me *MemoryExporter) Spans() []*Span {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	return append([]*Span{}, me.spans...)
+}
+
+// Discard the spans exported so far.
+func (me *MemoryExporter) Reset() {
+	me.mu.Lock()
+	defer me.mu.Unlock()
+
+	me.spans = nil
+}
@@ -0,0 +1,241 @@
+package uf
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func TestParseTraceContext(t *testing.T) {
+	tests := []struct {
+		header string
+		valid  bool
+	}{
+		{traceparent, true},
+		{"01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-later", true},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-later", false},
+		{"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", false},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", false},
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+		{"", false},
+	}
+
+	for _, test := range tests {
+		h := http.Header{"Traceparent": {test.header}}
+
+		if _, ok := ParseTraceContext(h); ok != test.valid {
+			t.Errorf("%q: expected valid %t", test.header, test.valid)
+		}
+	}
+
+	h := http.Header{"Traceparent": {traceparent}, "Tracestate": {"a=1", "b=2"}}
+	sc, _ := ParseTraceContext(h)
+
+	if sc.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("unexpected ids: %s %s", sc.TraceID, sc.SpanID)
+	}
+
+	if !sc.Sampled || sc.TraceState != "a=1,b=2" {
+		t.Errorf("unexpected context: %+v", sc)
+	}
+
+	out := http.Header{}
+	sc.Inject(out)
+
+	if out.Get("traceparent") != traceparent || out.Get("tracestate") != "a=1,b=2" {
+		t.Errorf("unexpected headers: %v", out)
+	}
+}
+
+func traceMiddleware(r *http.Request) error {
+	return nil
+}
+
+func TestTracing(t *testing.T) {
+	exporter := &MemoryExporter{}
+	s := NewServer(&Config{Tracer: NewTracer("garage", exporter)})
+	var outgoing http.Header
+
+	s.Get("/car/:id", func(w http.ResponseWriter, r *http.Request) error {
+		ctx, span := StartSpan(r.Context(), "query")
+		span.SetAttribute("db.system", "sqlite")
+		span.End()
+
+		outgoing = http.Header{}
+		InjectTraceContext(ctx, outgoing)
+
+		if GetParam(r, "id") == "0" {
+			return NotFound("no such car")
+		}
+
+		return SendJSON(w, "GT1")
+	}, traceMiddleware)
+
+	r := httptest.NewRequest(http.MethodGet, "/car/1", nil)
+	r.Header.Set("traceparent", traceparent)
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := exporter.Spans()
+
+	if len(spans) != 4 {
+		t.Fatalf("expected 4 spans; got %d", len(spans))
+	}
+
+	// in the order they ended
+	mw, query, handler, server := spans[0], spans[1], spans[2], spans[3]
+
+	if server.Name != "GET /car/:id" || server.Kind != SpanKindServer || server.Service != "garage" {
+		t.Errorf("unexpected server span: %+v", server)
+	}
+
+	if server.Context.TraceID.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent.String() != "00f067aa0ba902b7" {
+		t.Error("the server span must continue the trace of the traceparent header")
+	}
+
+	if server.Attributes["http.route"] != "/car/:id" || server.Attributes["http.response.status_code"] != http.StatusOK {
+		t.Errorf("unexpected attributes: %v", server.Attributes)
+	}
+
+	if mw.Name != "uf.traceMiddleware" || mw.Parent != server.Context.SpanID {
+		t.Errorf("unexpected middleware span: %+v", mw)
+	}
+
+	if handler.Parent != server.Context.SpanID || query.Parent != handler.Context.SpanID {
+		t.Error("the handler span must be a child of the server span and the parent of spans it starts")
+	}
+
+	if query.Attributes["db.system"] != "sqlite" {
+		t.Errorf("unexpected attributes: %v", query.Attributes)
+	}
+
+	if outgoing.Get("traceparent") != "00-4bf92f3577b34da6a3ce929d0e0e4736-"+query.Context.SpanID.String()+"-01" {
+		t.Errorf("unexpected traceparent: %s", outgoing.Get("traceparent"))
+	}
+
+	exporter.Reset()
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/car/0", nil))
+	spans = exporter.Spans()
+	handler, server = spans[2], spans[3]
+
+	if server.Parent.IsValid() || !server.Context.TraceID.IsValid() {
+		t.Error("requests without a traceparent must start a new trace")
+	}
+
+	if handler.Status != SpanStatusError || handler.StatusMessage != "no such car" {
+		t.Errorf("unexpected handler status: %v %q", handler.Status, handler.StatusMessage)
+	}
+
+	// client errors are not failures of the server
+	if server.Status != SpanStatusUnset || server.Attributes["http.response.status_code"] != http.StatusNotFound {
+		t.Errorf("unexpected server span: %+v", server)
+	}
+}
+
+func TestTracingUnsampled(t *testing.T) {
+	exporter := &MemoryExporter{}
+	s := NewServer(&Config{Tracer: NewTracer("garage", exporter)})
+
+	s.Get("/car", func(w http.ResponseWriter, r *http.Request) error {
+		return InternalServerError("engine failure")
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/car", nil)
+	r.Header.Set("traceparent", strings.TrimSuffix(traceparent, "01")+"00")
+	s.ServeHTTP(httptest.NewRecorder(), r)
+
+	if spans := exporter.Spans(); len(spans) != 0 {
+		t.Errorf("unsampled traces must not be exported; got %d spans", len(spans))
+	}
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/car", nil))
+
+	if spans := exporter.Spans(); len(spans) != 2 || spans[1].Status != SpanStatusError {
+		t.Errorf("server errors must mark the server span as failed: %+v", spans)
+	}
+}
+
+func TestStartSpanUntraced(t *testing.T) {
+	ctx, span := StartSpan(context.Background(), "query")
+
+	// methods of a nil span do nothing
+	span.SetAttribute("db.system", "sqlite")
+	span.SetError(NotFound("no such car"))
+	span.End()
+
+	if span != nil || ctx != context.Background() {
+		t.Error("untraced contexts must not start spans")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan map[string]interface{}, 10)
+
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+
+		if r.Header.Get("Content-Type") != "application/json" || r.Header.Get("Authorization") != "token" {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+
+		json.NewDecoder(r.Body).Decode(&body)
+		requests <- body
+	}))
+
+	defer collector.Close()
+
+	exporter := NewOTLPExporter(&OTLPOptions{
+		Endpoint:    collector.URL,
+		Headers:     map[string]string{"Authorization": "token"},
+		Interval:    time.Hour,
+		ErrorLogger: func(e error) { t.Error(e) },
+	})
+
+	s := NewServer(&Config{Tracer: NewTracer("garage", exporter)})
+
+	s.Get("/car", func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, "GT1")
+	})
+
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/car", nil))
+
+	if e := exporter.Shutdown(context.Background()); e != nil {
+		t.Fatal(e)
+	}
+
+	if e := exporter.Export(nil); e == nil {
+		t.Error("expected an error exporting after shutdown")
+	}
+
+	var body map[string]interface{}
+
+	select {
+	case body = <-requests:
+	default:
+		t.Fatal("spans were not sent on shutdown")
+	}
+
+	b, _ := json.Marshal(body)
+	str := string(b)
+
+	expected := []string{
+		`"resourceSpans":[{"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"garage"}}]}`,
+		`"scope":{"name":"github.com/blacksfk/uf"}`,
+		`"name":"GET /car"`,
+		`"kind":2`,
+		`{"key":"http.response.status_code","value":{"intValue":"200"}}`,
+	}
+
+	for _, e := range expected {
+		if !strings.Contains(str, e) {
+			t.Errorf("missing %s in:\n%s", e, str)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package uf
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+var txKey = NewKey[*txState]("uf.Tx")
+
+// The transaction of a request and the outcome of committing it.
+type txState struct {
+	tx  *sql.Tx
+	mu  sync.Mutex
+	err error
+
+	// whether the commit has been attempted
+	finished bool
+}
+
+// Commit the transaction unless it has already been committed. A failed
+// commit ends the transaction, so its error is returned by later calls.
+func (ts *txState) commit() error {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.finished {
+		ts.err = ts.tx.Commit()
+		ts.finished = true
+	}
+
+	return ts.err
+}
+
+// Middleware beginning a transaction on db for each request. The transaction
+// is committed once the handler has returned without error, before its
+// response is sent, and rolled back if the middleware following it or the
+// handler return an error or panic. The transaction is obtained with Tx.
+//
+// The response of the handler is buffered until the commit has succeeded. If
+// it fails (eg. a serialization failure) the response is discarded and the
+// commit error handled as if returned by the handler, so that the client does
+// not receive a success for a write that never happened.
+//
+// opts sets the isolation level and read-only mode and may be nil for the
+// driver's defaults. Bind the middleware per route to vary them:
+//
+//	server.Post("/transfer", handleTransfer, uf.Transaction(db, &sql.TxOptions{Isolation: sql.LevelSerializable}))
+//	server.Get("/report", handleReport, uf.Transaction(db, &sql.TxOptions{ReadOnly: true}))
+func Transaction(db *sql.DB, opts *sql.TxOptions) Middleware {
+	return func(r *http.Request) error {
+		tx, e := db.BeginTx(r.Context(), opts)
+
+		if e != nil {
+			return fmt.Errorf("begin transaction: %v", e)
+		}
+
+		ts := &txState{tx: tx}
+		txKey.Set(r, ts)
+
+		registered := Defer(r, func(e error) {
+			ts.mu.Lock()
+			defer ts.mu.Unlock()
+
+			if ts.finished {
+				// by the handler or before its response was sent
+				return
+			}
+
+			// the handler did not run or failed
+			if e := tx.Rollback(); e != nil {
+				stateFrom(r).logError(fmt.Errorf("transaction rollback: %v", e))
+			}
+		})
+
+		if !registered {
+			// nothing would finish the transaction
+			tx.Rollback()
+
+			return InternalServerError("Transaction middleware requires a Queue")
+		}
+
+		wrapHandler(r, commitBeforeResponding)
+
+		return nil
+	}
+}
+
+// Get the transaction begun for r by Transaction.
+func Tx(r *http.Request) (*sql.Tx, bool) {
+	ts, ok := txKey.Get(r)
+
+	if !ok {
+		return nil, false
+	}
+
+	return ts.tx, true
+}
+
+// Commit the transaction begun for r by Transaction before the handler
+// returns, eg. to act on its outcome. Handlers must do so with CommitTx rather
+// than sql.Tx.Commit so that the middleware does not commit it again.
+// Committing again returns the result of the first commit.
+func CommitTx(r *http.Request) error {
+	ts, ok := txKey.Get(r)
+
+	if !ok {
+		return InternalServerError("CommitTx requires the Transaction middleware")
+	}
+
+	return ts.commit()
+}
+
+// Wrap h to commit the transaction of the request before its buffered
+// response is sent.
+func commitBeforeResponding(h Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		// headers set by h are discarded along with the body if the commit fails
+		rec := &responseBuffer{header: w.Header().Clone()}
+
+		if e := h(rec, r); e != nil {
+			return e
+		}
+
+		if e := CommitTx(r); e != nil {
+			return fmt.Errorf("transaction commit: %w", e)
+		}
+
+		header := w.Header()
+
+		for k, v := range rec.header {
+			header[k] = v
+		}
+
+		// headers deleted by h
+		for k := range header {
+			if _, ok := rec.header[k]; !ok {
+				delete(header, k)
+			}
+		}
+
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		w.WriteHeader(rec.code)
+		_, e := w.Write(rec.body.Bytes())
+
+		return e
+	}
+}
@@ -0,0 +1,207 @@
+package uf
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// A database/sql driver recording the outcome of transactions.
+type txDriver struct {
+	mu       sync.Mutex
+	outcomes []string
+	levels   []sql.IsolationLevel
+
+	// error returned by commits
+	commitError error
+}
+
+func (d *txDriver) Open(name string) (driver.Conn, error) {
+	return &txConn{d}, nil
+}
+
+// Connect without registering the driver, which may only be done once.
+func (d *txDriver) Connect(ctx context.Context) (driver.Conn, error) {
+	return d.Open("")
+}
+
+func (d *txDriver) Driver() driver.Driver {
+	return d
+}
+
+func (d *txDriver) record(outcome string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.outcomes = append(d.outcomes, outcome)
+}
+
+type txConn struct {
+	d *txDriver
+}
+
+func (c *txConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("not supported")
+}
+
+func (c *txConn) Close() error {
+	return nil
+}
+
+func (c *txConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *txConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.d.mu.Lock()
+	c.d.levels = append(c.d.levels, sql.IsolationLevel(opts.Isolation))
+	c.d.mu.Unlock()
+
+	return &txTx{c.d}, nil
+}
+
+type txTx struct {
+	d *txDriver
+}
+
+func (tx *txTx) Commit() error {
+	tx.d.record("commit")
+
+	return tx.d.commitError
+}
+
+func (tx *txTx) Rollback() error {
+	tx.d.record("rollback")
+
+	return nil
+}
+
+func TestTransaction(t *testing.T) {
+	d := &txDriver{}
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	s := NewServer(&Config{})
+	handle := func(w http.ResponseWriter, r *http.Request) error {
+		if _, ok := Tx(r); !ok {
+			t.Error("transaction not in the request")
+		}
+
+		switch r.URL.Path {
+		case "/error":
+			return BadRequest("invalid car")
+		case "/panic":
+			panic("engine failure")
+		}
+
+		return nil
+	}
+
+	s.Post("/ok", handle, Transaction(db, &sql.TxOptions{Isolation: sql.LevelSerializable}))
+	s.Post("/error", handle, Transaction(db, nil))
+	s.Post("/panic", handle, Transaction(db, nil))
+
+	for _, path := range []string{"/ok", "/error", "/panic"} {
+		func() {
+			defer func() {
+				if p := recover(); (p != nil) != (path == "/panic") {
+					t.Errorf("%s: unexpected panic: %v", path, p)
+				}
+			}()
+
+			s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, path, nil))
+		}()
+	}
+
+	expected := []string{"commit", "rollback", "rollback"}
+
+	if len(d.outcomes) != len(expected) {
+		t.Fatalf("expected: %v, actual: %v", expected, d.outcomes)
+	}
+
+	for i, outcome := range expected {
+		if d.outcomes[i] != outcome {
+			t.Errorf("expected: %v, actual: %v", expected, d.outcomes)
+		}
+	}
+
+	if d.levels[0] != sql.LevelSerializable || d.levels[1] != sql.LevelDefault {
+		t.Errorf("unexpected isolation levels: %v", d.levels)
+	}
+}
+
+func TestTransactionCommitted(t *testing.T) {
+	d := &txDriver{}
+	db := sql.OpenDB(d)
+	defer db.Close()
+
+	var logged []error
+	s := NewServer(&Config{ErrorLogger: func(e error) { logged = append(logged, e) }})
+	created := func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusCreated)
+
+		return SendJSON(w, "transferred")
+	}
+
+	s.Post("/committed", created, Transaction(db, nil))
+	s.Post("/self", func(w http.ResponseWriter, r *http.Request) error {
+		if e := CommitTx(r); e != nil {
+			return e
+		}
+
+		return created(w, r)
+	}, Transaction(db, nil))
+	s.Post("/lost", func(w http.ResponseWriter, r *http.Request) error {
+		// eg. rolled back when the request context was cancelled
+		tx, _ := Tx(r)
+		tx.Rollback()
+
+		return created(w, r)
+	}, Transaction(db, nil))
+
+	post := func(path string) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		s.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+
+		return recorder
+	}
+
+	for _, path := range []string{"/committed", "/self"} {
+		if res := post(path); res.Code != http.StatusCreated || !strings.Contains(res.Body.String(), "transferred") {
+			t.Errorf("%s: unexpected response: %d %s", path, res.Code, res.Body)
+		}
+	}
+
+	if len(logged) != 0 || len(d.outcomes) != 2 {
+		t.Errorf("expected two commits without errors: %v %v", d.outcomes, logged)
+	}
+
+	// a failed commit is not reported as a success
+	if res := post("/lost"); res.Code != http.StatusInternalServerError || strings.Contains(res.Body.String(), "transferred") {
+		t.Errorf("a lost commit must not be reported as a success: %d %s", res.Code, res.Body)
+	}
+
+	if len(logged) != 1 || !errors.Is(logged[0], sql.ErrTxDone) {
+		t.Errorf("expected the lost commit to be logged: %v", logged)
+	}
+
+	// eg. a serialization failure
+	d.commitError = errors.New("could not serialize access")
+	logged = nil
+	res := post("/committed")
+
+	if res.Code != http.StatusInternalServerError || strings.Contains(res.Body.String(), "transferred") {
+		t.Errorf("a failed commit must not be reported as a success: %d %s", res.Code, res.Body)
+	}
+
+	// without a rollback of the finished transaction
+	if len(logged) != 1 || !strings.Contains(logged[0].Error(), "could not serialize access") {
+		t.Errorf("expected only the commit error to be logged: %v", logged)
+	}
+}
@@ -3,6 +3,8 @@ package uf
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -27,7 +29,13 @@ func SendErrorJSON(w http.ResponseWriter, he HttpError) error {
 	encoder := json.NewEncoder(w)
 
 	// write the headers
-	w.Header().Set("Content-Type", "application/json")
+	h := w.Header()
+
+	for k, v := range he.Header() {
+		h[k] = v
+	}
+
+	h.Set("Content-Type", "application/json")
 	w.WriteHeader(he.Code)
 
 	// attempt to encode the http error as JSON
@@ -35,7 +43,8 @@ func SendErrorJSON(w http.ResponseWriter, he HttpError) error {
 }
 
 // Returns the bytes read from r.Body. Returns a Bad Request error if the received Content-Type
-// header does not match any of the provided content types.
+// header does not match any of the provided content types. Bodies with a gzip or deflate
+// Content-Encoding are decompressed transparently.
 func ReadBody(r *http.Request, contentTypes ...string) ([]byte, error) {
 	if l := len(contentTypes); l > 0 {
 		// extract the media-type portion of the content-type header
@@ -61,7 +70,29 @@ func ReadBody(r *http.Request, contentTypes ...string) ([]byte, error) {
 	}
 
 	defer r.Body.Close()
-	return io.ReadAll(r.Body)
+	body, e := decodeBody(r.Body, r.Header.Get("Content-Encoding"))
+
+	if e != nil {
+		return nil, e
+	}
+
+	if body == r.Body {
+		return io.ReadAll(body)
+	}
+
+	// one more byte than allowed to detect larger bodies
+	b, e := io.ReadAll(io.LimitReader(body, MaxDecompressedSize+1))
+
+	if e != nil {
+		// corrupt compressed stream
+		return nil, BadRequest("Malformed request body: " + e.Error())
+	}
+
+	if int64(len(b)) > MaxDecompressedSize {
+		return nil, RequestEntityTooLarge(fmt.Sprintf("Decompressed request body exceeds %d bytes", MaxDecompressedSize))
+	}
+
+	return b, nil
 }
 
 // Decode the request body into ptr. Returns a 400 Bad Request error if the
@@ -80,25 +111,23 @@ func DecodeBodyJSON(r *http.Request, ptr interface{}) error {
 		return nil
 	}
 
-	_, ok := e.(HttpError)
-
-	if ok {
-		// bad content type encountered
+	if _, ok := AsHttpError(e); ok {
+		// returned by an UnmarshalJSON method
 		return e
 	}
 
-	se, ok := e.(*json.SyntaxError)
+	var se *json.SyntaxError
 
-	if ok {
+	if errors.As(e, &se) {
 		// malformed JSON body
 		return BadRequest(se.Error())
 	}
 
-	_, ok = e.(*json.InvalidUnmarshalError)
+	var ie *json.InvalidUnmarshalError
 
-	if ok {
+	if errors.As(e, &ie) {
 		// programmer error
-		return InternalServerError("DecodeBodyJSON requires a pointer")
+		return InternalServerError("DecodeBodyJSON requires a pointer").WithCause(e)
 	}
 
 	// some other error
@@ -0,0 +1,765 @@
+package uf
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Validates requests (and optionally responses) against a hand-written
+// OpenAPI 3 document in JSON format:
+//
+//	v, e := uf.LoadOpenAPIValidator("partner.json")
+//
+//	// ...
+//
+//	server := uf.NewServer(config, v.Middleware())
+//
+// Operations are found by matching the request's method and path against
+// the document's path templates; requests the document does not describe
+// are passed through.
+type OpenAPIValidator struct {
+	doc      *OpenAPI
+	paths    []*specPath
+	patterns map[string]*regexp.Regexp
+}
+
+// A path template of the document along with its operations.
+type specPath struct {
+	segments   []string
+	literals   int
+	params     []Parameter
+	operations map[string]*Operation
+}
+
+// Load an OpenAPIValidator from the JSON document in the file name.
+func LoadOpenAPIValidator(name string) (*OpenAPIValidator, error) {
+	b, e := os.ReadFile(name)
+
+	if e != nil {
+		return nil, e
+	}
+
+	return NewOpenAPIValidator(b)
+}
+
+// Create an OpenAPIValidator from a JSON document.
+func NewOpenAPIValidator(document []byte) (*OpenAPIValidator, error) {
+	var raw struct {
+		OpenAPI    string                                `json:"openapi"`
+		Info       OpenAPIInfo                           `json:"info"`
+		Paths      map[string]map[string]json.RawMessage `json:"paths"`
+		Components Components                            `json:"components"`
+	}
+
+	if e := json.Unmarshal(document, &raw); e != nil {
+		return nil, fmt.Errorf("OpenAPI document: %v", e)
+	}
+
+	v := &OpenAPIValidator{
+		doc: &OpenAPI{
+			OpenAPI:    raw.OpenAPI,
+			Info:       raw.Info,
+			Paths:      make(map[string]map[string]*Operation),
+			Components: raw.Components,
+		},
+		patterns: make(map[string]*regexp.Regexp),
+	}
+
+	for template, item := range raw.Paths {
+		sp := &specPath{segments: strings.Split(template, "/"), operations: make(map[string]*Operation)}
+
+		for _, segment := range sp.segments {
+			if !isTemplate(segment) {
+				sp.literals++
+			}
+		}
+
+		for key, value := range item {
+			var e error
+
+			switch key = strings.ToUpper(key); key {
+			case "PARAMETERS":
+				e = json.Unmarshal(value, &sp.params)
+			case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut,
+				http.MethodPatch, http.MethodDelete, http.MethodOptions, http.MethodTrace:
+				op := &Operation{}
+				e = json.Unmarshal(value, op)
+				sp.operations[key] = op
+			}
+
+			if e != nil {
+				return nil, fmt.Errorf("OpenAPI document: %s: %s: %v", template, key, e)
+			}
+		}
+
+		v.doc.Paths[template] = make(map[string]*Operation)
+
+		for method, op := range sp.operations {
+			v.doc.Paths[template][strings.ToLower(method)] = op
+		}
+
+		v.paths = append(v.paths, sp)
+	}
+
+	// prefer the most specific template when several match
+	sort.Slice(v.paths, func(i, j int) bool {
+		return v.paths[i].literals > v.paths[j].literals
+	})
+
+	if e := v.compilePatterns(); e != nil {
+		return nil, e
+	}
+
+	return v, nil
+}
+
+// The parsed document.
+func (v *OpenAPIValidator) Document() *OpenAPI {
+	return v.doc
+}
+
+// Middleware validating the path, query and header parameters and the JSON
+// body of requests before the handler. Violations are reported together in
+// a single 400 Bad Request error.
+func (v *OpenAPIValidator) Middleware() Middleware {
+	return v.validateRequest
+}
+
+// Wrap h in order to validate the JSON bodies of its responses against the
+// document. Intended for tests and development: responses are buffered and
+// any that do not match are replaced with a 500 Internal Server Error
+// describing the drift.
+func (v *OpenAPIValidator) ValidateResponses(h Handler) Handler {
+	return func(w http.ResponseWriter, r *http.Request) error {
+		op, _ := v.find(r)
+
+		if op == nil {
+			return h(w, r)
+		}
+
+		rec := &responseBuffer{header: w.Header()}
+
+		if e := h(rec, r); e != nil {
+			return e
+		}
+
+		if rec.code == 0 {
+			rec.code = http.StatusOK
+		}
+
+		if errs := v.checkResponse(op, rec); len(errs) > 0 {
+			return InternalServerError("Response does not match the specification: " + strings.Join(errs, "; "))
+		}
+
+		w.WriteHeader(rec.code)
+		_, e := w.Write(rec.body.Bytes())
+
+		return e
+	}
+}
+
+// Find the operation describing r along with the values of its path parameters.
+func (v *OpenAPIValidator) find(r *http.Request) (*Operation, map[string]string) {
+	segments := strings.Split(r.URL.Path, "/")
+
+	for _, sp := range v.paths {
+		op := sp.operations[r.Method]
+
+		if op == nil || len(sp.segments) != len(segments) {
+			continue
+		}
+
+		params := make(map[string]string)
+		matched := true
+
+		for i, segment := range sp.segments {
+			if isTemplate(segment) {
+				params[segment[1:len(segment)-1]] = segments[i]
+			} else if segment != segments[i] {
+				matched = false
+
+				break
+			}
+		}
+
+		if matched {
+			return op, params
+		}
+	}
+
+	return nil, nil
+}
+
+func (v *OpenAPIValidator) validateRequest(r *http.Request) error {
+	op, pathParams := v.find(r)
+
+	if op == nil {
+		return nil
+	}
+
+	var errs []string
+	query := r.URL.Query()
+
+	for _, p := range v.parameters(r, op) {
+		var values []string
+
+		switch p.In {
+		case "path":
+			values = []string{pathParams[p.Name]}
+		case "query":
+			values = query[p.Name]
+		case "header":
+			values = r.Header.Values(p.Name)
+		default:
+			continue
+		}
+
+		name := p.In + "." + p.Name
+
+		if len(values) == 0 || (len(values) == 1 && values[0] == "" && p.In != "query") {
+			if p.Required {
+				errs = append(errs, name+": is required")
+			}
+
+			continue
+		}
+
+		if p.Schema == nil {
+			continue
+		}
+
+		value, e := coerce(values, v.resolve(p.Schema))
+
+		if e != nil {
+			errs = append(errs, name+": "+e.Error())
+		} else {
+			errs = v.validate(p.Schema, value, name, errs)
+		}
+	}
+
+	if op.RequestBody != nil {
+		errs = v.checkRequestBody(r, op.RequestBody, errs)
+	}
+
+	if len(errs) > 0 {
+		return BadRequest(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// Merge the path-level and operation parameters, resolving references.
+// Operation parameters override path-level parameters of the same name and location.
+func (v *OpenAPIValidator) parameters(r *http.Request, op *Operation) []Parameter {
+	var params []Parameter
+
+	for _, sp := range v.paths {
+		if sp.operations[r.Method] == op {
+			params = append(params, sp.params...)
+
+			break
+		}
+	}
+
+	params = append(params, op.Parameters...)
+	merged := make([]Parameter, 0, len(params))
+	index := make(map[string]int)
+
+	for _, p := range params {
+		if p.Ref != "" {
+			name := strings.TrimPrefix(p.Ref, "#/components/parameters/")
+
+			if ref, ok := v.doc.Components.Parameters[name]; ok {
+				p = *ref
+			}
+		}
+
+		key := p.In + "." + p.Name
+
+		if i, ok := index[key]; ok {
+			merged[i] = p
+		} else {
+			index[key] = len(merged)
+			merged = append(merged, p)
+		}
+	}
+
+	return merged
+}
+
+// Validate the JSON body of r, restoring it for the handler to read.
+func (v *OpenAPIValidator) checkRequestBody(r *http.Request, rb *RequestBody, errs []string) []string {
+	media, ok := rb.Content["application/json"]
+
+	if !ok {
+		return errs
+	}
+
+	var b []byte
+
+	if r.Body != nil && r.Body != http.NoBody && r.ContentLength != 0 {
+		var e error
+
+		if b, e = ReadBody(r, "application/json"); e != nil {
+			if he, ok := AsHttpError(e); ok {
+				return append(errs, "body: "+he.Message)
+			}
+
+			return append(errs, "body: "+e.Error())
+		}
+
+		// the body is restored decompressed
+		r.Body = io.NopCloser(bytes.NewReader(b))
+		r.ContentLength = int64(len(b))
+		r.Header.Del("Content-Encoding")
+		r.Header.Del("Content-Length")
+	}
+
+	if len(bytes.TrimSpace(b)) == 0 {
+		if rb.Required {
+			errs = append(errs, "body: is required")
+		}
+
+		return errs
+	}
+
+	value, e := decodeJSON(b)
+
+	if e != nil {
+		return append(errs, "body: "+e.Error())
+	}
+
+	if media.Schema == nil {
+		return errs
+	}
+
+	return v.validate(media.Schema, value, "body", errs)
+}
+
+// Validate a buffered response against the operation's response for its status code.
+func (v *OpenAPIValidator) checkResponse(op *Operation, rec *responseBuffer) []string {
+	code := strconv.Itoa(rec.code)
+	res := op.Responses[code]
+
+	if res == nil {
+		// eg. 2XX
+		res = op.Responses[code[:1]+"XX"]
+	}
+
+	if res == nil {
+		res = op.Responses["default"]
+	}
+
+	if res == nil {
+		return []string{"status " + code + " is not documented"}
+	}
+
+	media, ok := res.Content["application/json"]
+
+	if !ok || media.Schema == nil {
+		return nil
+	}
+
+	value, e := decodeJSON(rec.body.Bytes())
+
+	if e != nil {
+		return []string{"body: " + e.Error()}
+	}
+
+	return v.validate(media.Schema, value, "body", nil)
+}
+
+// Follow the schema's reference, if any.
+func (v *OpenAPIValidator) resolve(s *Schema) *Schema {
+	for i := 0; s.Ref != "" && i < 32; i++ {
+		ref, ok := v.doc.Components.Schemas[strings.TrimPrefix(s.Ref, "#/components/schemas/")]
+
+		if !ok || ref == nil {
+			break
+		}
+
+		s = ref
+	}
+
+	return s
+}
+
+// Compile the pattern of every schema in the document so that invalid
+// patterns are reported when loading.
+func (v *OpenAPIValidator) compilePatterns() error {
+	var visit func(s *Schema) error
+	seen := make(map[*Schema]bool)
+
+	visit = func(s *Schema) error {
+		if s == nil || seen[s] {
+			return nil
+		}
+
+		seen[s] = true
+
+		if s.Pattern != "" {
+			re, e := regexp.Compile(s.Pattern)
+
+			if e != nil {
+				return fmt.Errorf("OpenAPI document: pattern %q: %v", s.Pattern, e)
+			}
+
+			v.patterns[s.Pattern] = re
+		}
+
+		children := []*Schema{s.Items, s.AdditionalProperties}
+		children = append(children, s.AllOf...)
+		children = append(children, s.AnyOf...)
+		children = append(children, s.OneOf...)
+
+		for _, p := range s.Properties {
+			children = append(children, p)
+		}
+
+		for _, c := range children {
+			if e := visit(c); e != nil {
+				return e
+			}
+		}
+
+		return nil
+	}
+
+	var schemas []*Schema
+
+	for _, s := range v.doc.Components.Schemas {
+		schemas = append(schemas, s)
+	}
+
+	for _, p := range v.doc.Components.Parameters {
+		schemas = append(schemas, p.Schema)
+	}
+
+	for _, sp := range v.paths {
+		for _, p := range sp.params {
+			schemas = append(schemas, p.Schema)
+		}
+
+		for _, op := range sp.operations {
+			for _, p := range op.Parameters {
+				schemas = append(schemas, p.Schema)
+			}
+
+			if op.RequestBody != nil {
+				for _, m := range op.RequestBody.Content {
+					schemas = append(schemas, m.Schema)
+				}
+			}
+
+			for _, res := range op.Responses {
+				for _, m := range res.Content {
+					schemas = append(schemas, m.Schema)
+				}
+			}
+		}
+	}
+
+	for _, s := range schemas {
+		if e := visit(s); e != nil {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// Validate value (as decoded by decodeJSON) against s, appending any
+// violations prefixed with path to errs.
+func (v *OpenAPIValidator) validate(s *Schema, value interface{}, path string, errs []string) []string {
+	s = v.resolve(s)
+
+	if value == nil {
+		if s.nullable || s.Type == "" {
+			return errs
+		}
+
+		return append(errs, path+": must not be null")
+	}
+
+	for _, sub := range s.AllOf {
+		errs = v.validate(sub, value, path, errs)
+	}
+
+	if len(s.AnyOf) > 0 && v.matches(s.AnyOf, value) == 0 {
+		errs = append(errs, path+": must match at least one schema")
+	}
+
+	if len(s.OneOf) > 0 && v.matches(s.OneOf, value) != 1 {
+		errs = append(errs, path+": must match exactly one schema")
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		errs = append(errs, fmt.Sprintf("%s: must be one of %v", path, s.Enum))
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if s.Type != "" && s.Type != "object" {
+			return append(errs, path+": must be of type "+s.Type)
+		}
+
+		for _, name := range s.Required {
+			if _, ok := value[name]; !ok {
+				errs = append(errs, path+"."+name+": is required")
+			}
+		}
+
+		// sort for deterministic messages
+		keys := make([]string, 0, len(value))
+
+		for k := range value {
+			keys = append(keys, k)
+		}
+
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			if p, ok := s.Properties[k]; ok {
+				errs = v.validate(p, value[k], path+"."+k, errs)
+			} else if s.closed {
+				errs = append(errs, path+"."+k+": is not allowed")
+			} else if s.AdditionalProperties != nil {
+				errs = v.validate(s.AdditionalProperties, value[k], path+"."+k, errs)
+			}
+		}
+	case []interface{}:
+		if s.Type != "" && s.Type != "array" {
+			return append(errs, path+": must be of type "+s.Type)
+		}
+
+		if s.MinItems != nil && len(value) < *s.MinItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at least %d items", path, *s.MinItems))
+		}
+
+		if s.MaxItems != nil && len(value) > *s.MaxItems {
+			errs = append(errs, fmt.Sprintf("%s: must have at most %d items", path, *s.MaxItems))
+		}
+
+		if s.Items != nil {
+			for i, item := range value {
+				errs = v.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+			}
+		}
+	case string:
+		if s.Type != "" && s.Type != "string" {
+			return append(errs, path+": must be of type "+s.Type)
+		}
+
+		errs = v.validateString(s, value, path, errs)
+	case json.Number:
+		f, _ := value.Float64()
+
+		if s.Type == "integer" && f != math.Trunc(f) {
+			return append(errs, path+": must be an integer")
+		}
+
+		if s.Type != "" && s.Type != "integer" && s.Type != "number" {
+			return append(errs, path+": must be of type "+s.Type)
+		}
+
+		errs = validateNumber(s, f, path, errs)
+	case bool:
+		if s.Type != "" && s.Type != "boolean" {
+			return append(errs, path+": must be of type "+s.Type)
+		}
+	}
+
+	return errs
+}
+
+// Count the schemas value is valid against.
+func (v *OpenAPIValidator) matches(schemas []*Schema, value interface{}) int {
+	n := 0
+
+	for _, s := range schemas {
+		if len(v.validate(s, value, "", nil)) == 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+func (v *OpenAPIValidator) validateString(s *Schema, value, path string, errs []string) []string {
+	l := len([]rune(value))
+
+	if s.MinLength != nil && l < *s.MinLength {
+		errs = append(errs, fmt.Sprintf("%s: must be at least %d characters", path, *s.MinLength))
+	}
+
+	if s.MaxLength != nil && l > *s.MaxLength {
+		errs = append(errs, fmt.Sprintf("%s: must be at most %d characters", path, *s.MaxLength))
+	}
+
+	if re := v.patterns[s.Pattern]; re != nil && !re.MatchString(value) {
+		errs = append(errs, fmt.Sprintf("%s: must match %s", path, s.Pattern))
+	}
+
+	var e error
+
+	switch s.Format {
+	case "date-time":
+		_, e = time.Parse(time.RFC3339, value)
+	case "date":
+		_, e = time.Parse("2006-01-02", value)
+	case "uuid":
+		if !uuidPattern.MatchString(value) {
+			e = fmt.Errorf("invalid uuid")
+		}
+	}
+
+	if e != nil {
+		errs = append(errs, path+": must be a valid "+s.Format)
+	}
+
+	return errs
+}
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+func validateNumber(s *Schema, f float64, path string, errs []string) []string {
+	if s.Minimum != nil && f < *s.Minimum {
+		errs = append(errs, fmt.Sprintf("%s: must be >= %v", path, *s.Minimum))
+	}
+
+	if s.Maximum != nil && f > *s.Maximum {
+		errs = append(errs, fmt.Sprintf("%s: must be <= %v", path, *s.Maximum))
+	}
+
+	if s.ExclusiveMinimum != nil && f <= *s.ExclusiveMinimum {
+		errs = append(errs, fmt.Sprintf("%s: must be > %v", path, *s.ExclusiveMinimum))
+	}
+
+	if s.ExclusiveMaximum != nil && f >= *s.ExclusiveMaximum {
+		errs = append(errs, fmt.Sprintf("%s: must be < %v", path, *s.ExclusiveMaximum))
+	}
+
+	return errs
+}
+
+// Compare value against the enum values, which are decoded without UseNumber.
+func inEnum(enum []interface{}, value interface{}) bool {
+	if n, ok := value.(json.Number); ok {
+		f, _ := n.Float64()
+		value = f
+	}
+
+	for _, candidate := range enum {
+		if reflect.DeepEqual(candidate, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Convert the string values of a parameter into the type described by s.
+func coerce(values []string, s *Schema) (interface{}, error) {
+	if s.Type == "array" {
+		if len(values) == 1 {
+			// form style: comma separated
+			values = strings.Split(values[0], ",")
+		}
+
+		items := make([]interface{}, len(values))
+
+		for i, value := range values {
+			item := &Schema{}
+
+			if s.Items != nil {
+				item = s.Items
+			}
+
+			v, e := coerce([]string{value}, item)
+
+			if e != nil {
+				return nil, e
+			}
+
+			items[i] = v
+		}
+
+		return items, nil
+	}
+
+	value := values[0]
+
+	switch s.Type {
+	case "integer", "number":
+		if _, e := strconv.ParseFloat(value, 64); e != nil {
+			return nil, fmt.Errorf("must be a number")
+		}
+
+		return json.Number(value), nil
+	case "boolean":
+		b, e := strconv.ParseBool(value)
+
+		if e != nil {
+			return nil, fmt.Errorf("must be a boolean")
+		}
+
+		return b, nil
+	}
+
+	return value, nil
+}
+
+// Decode JSON keeping numbers precise.
+func decodeJSON(b []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+
+	var value interface{}
+
+	if e := decoder.Decode(&value); e != nil {
+		return nil, e
+	}
+
+	return value, nil
+}
+
+// Determine whether a path segment is a template expression. Eg. {id}.
+func isTemplate(segment string) bool {
+	return len(segment) > 2 && segment[0] == '{' && segment[len(segment)-1] == '}'
+}
+
+// Buffers a response in order to inspect it before it is sent.
+type responseBuffer struct {
+	header http.Header
+	body   bytes.Buffer
+	code   int
+}
+
+func (rb *responseBuffer) Header() http.Header {
+	return rb.header
+}
+
+func (rb *responseBuffer) Write(b []byte) (int, error) {
+	if rb.code == 0 {
+		rb.code = http.StatusOK
+	}
+
+	return rb.body.Write(b)
+}
+
+func (rb *responseBuffer) WriteHeader(code int) {
+	if rb.code == 0 {
+		rb.code = code
+	}
+}
@@ -0,0 +1,201 @@
+package uf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const carSpec = `{
+	"openapi": "3.1.0",
+	"info": {"title": "Le Mans", "version": "1.0.0"},
+	"paths": {
+		"/car/{id}": {
+			"parameters": [{"name": "id", "in": "path", "required": true, "schema": {"type": "integer", "minimum": 1}}],
+			"get": {
+				"parameters": [
+					{"$ref": "#/components/parameters/limit"},
+					{"name": "X-Team", "in": "header", "required": true, "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {"description": "OK", "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GT1"}}}}
+				}
+			},
+			"put": {
+				"requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/GT1"}}}},
+				"responses": {"204": {"description": "No Content"}}
+			}
+		},
+		"/car/latest": {
+			"get": {"responses": {"200": {"description": "OK"}}}
+		}
+	},
+	"components": {
+		"parameters": {
+			"limit": {"name": "limit", "in": "query", "schema": {"type": "integer", "maximum": 100}}
+		},
+		"schemas": {
+			"GT1": {
+				"type": "object",
+				"required": ["Manufacturer", "Model", "Debut"],
+				"additionalProperties": false,
+				"properties": {
+					"Manufacturer": {"type": "string", "enum": ["BMW", "Nissan", "Porsche"]},
+					"Model": {"type": "string", "minLength": 1, "pattern": "^[A-Z0-9]"},
+					"Debut": {"type": "integer", "minimum": 1994},
+					"Notes": {"type": ["string", "null"]}
+				}
+			}
+		}
+	}
+}`
+
+func TestOpenAPIValidator(t *testing.T) {
+	v, e := NewOpenAPIValidator([]byte(carSpec))
+
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var received string
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		// the body must still be readable after validation
+		b, _ := io.ReadAll(r.Body)
+		received = string(b)
+
+		return nil
+	}, []Middleware{v.Middleware()}, &Config{})
+
+	tests := []struct {
+		method, path, team, body string
+		errors                   []string
+	}{
+		{http.MethodGet, "/car/1?limit=10", "BMW", "", nil},
+		{http.MethodGet, "/car/0?limit=101", "", "", []string{"path.id: must be >= 1", "query.limit: must be <= 100", "header.X-Team: is required"}},
+		{http.MethodGet, "/car/abc", "BMW", "", []string{"path.id: must be a number"}},
+		{http.MethodGet, "/car/latest", "", "", nil},
+		{http.MethodGet, "/undocumented", "", "", nil},
+		{http.MethodPut, "/car/1", "", `{"Manufacturer": "Nissan", "Model": "R390 LM", "Debut": 1997, "Notes": null}`, nil},
+		{http.MethodPut, "/car/1", "", `{"Manufacturer": "Ford", "Model": "gt40", "Debut": 1966.5, "Wins": 4}`, []string{
+			"body.Debut: must be an integer",
+			"body.Manufacturer: must be one of [BMW Nissan Porsche]",
+			"body.Model: must match ^[A-Z0-9]",
+			"body.Wins: is not allowed",
+		}},
+		{http.MethodPut, "/car/1", "", `{"Model": ""}`, []string{"body.Manufacturer: is required", "body.Debut: is required", "body.Model: must be at least 1 characters"}},
+		{http.MethodPut, "/car/1", "", "", []string{"body: is required"}},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		r := httptest.NewRequest(test.method, test.path, strings.NewReader(test.body))
+
+		if test.body != "" {
+			r.Header.Set("Content-Type", "application/json")
+		}
+
+		if test.team != "" {
+			r.Header.Set("X-Team", test.team)
+		}
+
+		received = ""
+		q.ServeHTTP(recorder, r)
+
+		if len(test.errors) == 0 {
+			if recorder.Code != http.StatusOK {
+				t.Errorf("%s %s: unexpected rejection: %s", test.method, test.path, recorder.Body)
+			}
+
+			if received != test.body {
+				t.Errorf("%s %s: body was not restored: %q", test.method, test.path, received)
+			}
+
+			continue
+		}
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s %s: expected 400, received %d", test.method, test.path, recorder.Code)
+		}
+
+		he := HttpError{}
+		json.NewDecoder(recorder.Body).Decode(&he)
+
+		for _, message := range test.errors {
+			if !strings.Contains(he.Message, message) {
+				t.Errorf("%s %s: %q missing from %s", test.method, test.path, message, he.Message)
+			}
+		}
+	}
+}
+
+func TestOpenAPIValidatorGzip(t *testing.T) {
+	v, e := NewOpenAPIValidator([]byte(carSpec))
+
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	var car GT1
+	q := newQueue(func(w http.ResponseWriter, r *http.Request) error {
+		return DecodeBodyJSON(r, &car)
+	}, []Middleware{v.Middleware()}, &Config{})
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(`{"Manufacturer": "Porsche", "Model": "911 GT1", "Debut": 1996}`))
+	zw.Close()
+
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPut, "/car/1", &buf)
+	r.Header.Set("Content-Type", "application/json")
+	r.Header.Set("Content-Encoding", "gzip")
+	q.ServeHTTP(recorder, r)
+
+	if recorder.Code != http.StatusOK || car.Model != "911 GT1" {
+		t.Errorf("the handler could not read the validated body: %d %s", recorder.Code, recorder.Body)
+	}
+}
+
+func TestOpenAPIValidatorResponses(t *testing.T) {
+	v, e := NewOpenAPIValidator([]byte(carSpec))
+
+	if e != nil {
+		t.Fatal(e)
+	}
+
+	car := GT1{"Porsche", "911 GT1", 1996}
+	q := newQueue(v.ValidateResponses(func(w http.ResponseWriter, r *http.Request) error {
+		return SendJSON(w, car)
+	}), nil, &Config{})
+
+	recorder := httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/car/1", nil))
+
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "911 GT1") {
+		t.Errorf("valid response was not sent: %d %s", recorder.Code, recorder.Body)
+	}
+
+	car.Debut = 1980
+	recorder = httptest.NewRecorder()
+	q.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/car/1", nil))
+
+	he := HttpError{}
+	json.NewDecoder(recorder.Body).Decode(&he)
+
+	if recorder.Code != http.StatusInternalServerError || !strings.Contains(he.Message, "body.Debut: must be >= 1994") {
+		t.Errorf("invalid response was not reported: %d %s", recorder.Code, he.Message)
+	}
+}
+
+func TestOpenAPIValidatorInvalidPattern(t *testing.T) {
+	_, e := NewOpenAPIValidator([]byte(`{"components": {"schemas": {"Bad": {"type": "string", "pattern": "("}}}}`))
+
+	if e == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}